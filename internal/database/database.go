@@ -11,8 +11,8 @@ import (
 
 var DB *sql.DB
 
-// Init 初始化数据库连接
-func Init() error {
+// ConnString 根据环境变量生成数据库连接字符串
+func ConnString() string {
 	// 从环境变量读取数据库连接信息
 	host := getEnv("DB_HOST", "localhost")
 	port := getEnv("DB_PORT", "5432")
@@ -20,11 +20,14 @@ func Init() error {
 	password := getEnv("DB_PASSWORD", "postgres")
 	dbname := getEnv("DB_NAME", "gochat")
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
+}
 
+// Init 初始化数据库连接
+func Init() error {
 	var err error
-	DB, err = sql.Open("postgres", connStr)
+	DB, err = sql.Open("postgres", ConnString())
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -52,7 +55,7 @@ func RunMigrations(migrationPath string) error {
 		return fmt.Errorf("failed to execute migration: %w", err)
 	}
 
-	log.Println("Migration completed successfully")
+	log.Printf("Migration %s completed successfully", migrationPath)
 	return nil
 }
 
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/services/hub"
+	"go-chat/internal/services/mailer"
+	"go-chat/internal/services/token"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// verifyEmailTTL 邮箱验证链接的有效期
+	verifyEmailTTL = 48 * time.Hour
+	// resetPasswordTTL 重置密码链接的有效期
+	resetPasswordTTL = time.Hour
+	// mailSendTimeout 发送一封邮件的超时时间
+	mailSendTimeout = 30 * time.Second
+)
+
+// AccountOptions 邮箱验证和重置密码所需的依赖和配置
+type AccountOptions struct {
+	Mailer mailer.Mailer
+	Tokens *token.Issuer
+	// BaseURL 邮件中链接的前缀，如 https://chat.example.com
+	// 不从请求的 Host 推断，避免攻击者伪造 Host 让重置密码链接指向自己的站点
+	BaseURL string
+	// RequireEmailVerification 为 true 时邮箱未验证的用户不能登录
+	RequireEmailVerification bool
+	// Throttle 登录失败限流
+	Throttle *LoginThrottle
+}
+
+// link 生成带令牌的链接
+func (o *AccountOptions) link(path, tok string) string {
+	return strings.TrimRight(o.BaseURL, "/") + path + "?token=" + url.QueryEscape(tok)
+}
+
+// sendMail 在后台发送邮件，接口响应时间不受邮件服务器影响，也不会暴露邮箱是否已注册
+func (o *AccountOptions) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+
+		if err := o.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("Error sending mail to %s: %v", msg.To, err)
+		}
+	}()
+}
+
+// sendVerification 签发邮箱验证令牌并发送验证邮件
+func (o *AccountOptions) sendVerification(userID int, username, email string) error {
+	tok, err := o.Tokens.Issue(userID, token.PurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	o.sendMail(mailer.Message{
+		To:      email,
+		Subject: "验证你的 Go Chat 邮箱",
+		Body: fmt.Sprintf(
+			"%s，你好：\n\n请打开下面的链接验证你的邮箱（%d 小时内有效）：\n\n%s\n\n如果你没有注册 Go Chat，请忽略这封邮件。\n",
+			username, int(verifyEmailTTL.Hours()), o.link("/verify-email", tok),
+		),
+	})
+	return nil
+}
+
+// ShowVerifyEmail 打开邮件中的验证链接：核销令牌并标记邮箱已验证
+func ShowVerifyEmail(opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verified := false
+
+		userID, err := opts.Tokens.Consume(database.DB, r.URL.Query().Get("token"), token.PurposeVerifyEmail)
+		if err == nil {
+			_, err = database.DB.Exec(
+				"UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1",
+				userID,
+			)
+			verified = err == nil
+		}
+		if err != nil && err != token.ErrInvalid {
+			log.Printf("Error verifying email: %v", err)
+		}
+
+		data := struct {
+			Verified bool
+		}{
+			Verified: verified,
+		}
+
+		tmpl := template.Must(template.ParseFiles("web/templates/verify_email.html"))
+		tmpl.Execute(w, data)
+	}
+}
+
+// ResendVerification 重新发送验证邮件
+// 无论邮箱是否存在、是否已验证都返回相同的结果，避免泄露已注册的邮箱
+func ResendVerification(opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var user models.User
+		err := database.DB.QueryRow(
+			"SELECT id, username, email FROM users WHERE email = $1 AND email_verified_at IS NULL",
+			strings.TrimSpace(req.Email),
+		).Scan(&user.ID, &user.Username, &user.Email)
+
+		if err == nil {
+			err = opts.sendVerification(user.ID, user.Username, user.Email)
+		}
+		if err != nil && err != sql.ErrNoRows && err != token.ErrTooSoon {
+			log.Printf("Error resending verification: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "If the email is registered and not yet verified, a verification link has been sent",
+		})
+	}
+}
+
+// ShowForgotPasswordPage 显示忘记密码页面
+func ShowForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.ParseFiles("web/templates/forgot_password.html"))
+	tmpl.Execute(w, nil)
+}
+
+// ForgotPassword 发送重置密码邮件
+// 无论邮箱是否存在都返回相同的结果，避免泄露已注册的邮箱
+func ForgotPassword(opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var user models.User
+		err := database.DB.QueryRow(
+			"SELECT id, username, email FROM users WHERE email = $1",
+			strings.TrimSpace(req.Email),
+		).Scan(&user.ID, &user.Username, &user.Email)
+
+		var tok string
+		if err == nil {
+			tok, err = opts.Tokens.Issue(user.ID, token.PurposeResetPassword, resetPasswordTTL)
+		}
+		if err == nil {
+			opts.sendMail(mailer.Message{
+				To:      user.Email,
+				Subject: "重置你的 Go Chat 密码",
+				Body: fmt.Sprintf(
+					"%s，你好：\n\n请打开下面的链接设置新密码（%d 分钟内有效，只能使用一次）：\n\n%s\n\n如果你没有申请重置密码，请忽略这封邮件，你的密码不会改变。\n",
+					user.Username, int(resetPasswordTTL.Minutes()), opts.link("/reset-password", tok),
+				),
+			})
+		} else if err != sql.ErrNoRows && err != token.ErrTooSoon {
+			log.Printf("Error issuing password reset token: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "If the email is registered, a password reset link has been sent",
+		})
+	}
+}
+
+// ShowResetPasswordPage 显示重置密码页面，令牌在提交新密码时才核销
+func ShowResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Token string
+	}{
+		Token: r.URL.Query().Get("token"),
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/reset_password.html"))
+	tmpl.Execute(w, data)
+}
+
+// ResetPassword 使用重置密码令牌设置新密码
+// 令牌核销和修改密码在同一事务中完成；成功后用户在所有设备上退出登录
+func ResetPassword(h *hub.Hub, opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Password == "" {
+			http.Error(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		userID, err := opts.Tokens.Consume(tx, req.Token, token.PurposeResetPassword)
+		if err == token.ErrInvalid {
+			http.Error(w, "The reset link is invalid or has expired", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error consuming password reset token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 能收到重置邮件说明邮箱属于该用户，同时视为已验证
+		if _, err = tx.Exec(`
+			UPDATE users
+			SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2
+		`, string(hashedPassword), userID); err != nil {
+			log.Printf("Error updating password: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := middleware.SessionStore().RevokeUser(userID); err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+		}
+		h.DisconnectUserEverywhere(userID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "password reset")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Password has been reset, please log in again",
+		})
+	}
+}
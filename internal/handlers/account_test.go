@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/services/hub"
+	"go-chat/internal/services/mailer"
+	"go-chat/internal/services/session"
+	"go-chat/internal/services/token"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testDatabaseEnv 指向测试数据库的连接字符串，未设置时跳过需要 Postgres 的测试
+const testDatabaseEnv = "GOCHAT_TEST_DATABASE_URL"
+
+// setupTestDB 连接测试数据库、运行全部迁移并替换全局的 database.DB
+func setupTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		content, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("read migration: %v", err)
+		}
+		if _, err := db.Exec(string(content)); err != nil {
+			t.Fatalf("run migration %s: %v", migration, err)
+		}
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+	return db
+}
+
+// newTestAccountOptions 使用 outbox 邮件后端，返回的目录中保存发出的邮件
+func newTestAccountOptions(t *testing.T, db *sql.DB) (*AccountOptions, string) {
+	t.Helper()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	middleware.SetSessionStore(session.NewStore(db, secret))
+
+	dir := t.TempDir()
+	outbox, err := mailer.NewOutboxMailer(dir)
+	if err != nil {
+		t.Fatalf("NewOutboxMailer: %v", err)
+	}
+	return &AccountOptions{
+		Mailer:  outbox,
+		Tokens:  token.NewIssuer(db, secret),
+		BaseURL: "https://chat.example.com",
+	}, dir
+}
+
+// createUnverifiedUser 创建一个邮箱未验证的用户，测试结束后删除
+func createUnverifiedUser(t *testing.T, db *sql.DB, password string) (int, string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+
+	name := fmt.Sprintf("account_test_%d", time.Now().UnixNano())
+	email := name + "@example.com"
+	var id int
+	if err := db.QueryRow(
+		"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id",
+		name, email, string(hash),
+	).Scan(&id); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", id) })
+	return id, email
+}
+
+// waitForMailLink 等待 outbox 中出现一封邮件，返回其中链接的令牌
+func waitForMailLink(t *testing.T, dir, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if len(files) > 0 {
+			content, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatalf("read mail: %v", err)
+			}
+			os.Remove(files[0])
+
+			prefix := "https://chat.example.com" + path + "?token="
+			i := strings.Index(string(content), prefix)
+			if i < 0 {
+				t.Fatalf("mail does not contain a %s link:\n%s", path, content)
+			}
+			link := strings.Fields(string(content)[i:])[0]
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatalf("parse link: %v", err)
+			}
+			return u.Query().Get("token")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no mail was sent")
+	return ""
+}
+
+// post 以 JSON 请求体调用处理器
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	return w
+}
+
+func TestVerifyEmailFlow(t *testing.T) {
+	db := setupTestDB(t)
+	opts, outbox := newTestAccountOptions(t, db)
+	userID, email := createUnverifiedUser(t, db, "secret")
+	// 验证页面模板使用相对于仓库根目录的路径
+	t.Chdir("../..")
+
+	if w := post(ResendVerification(opts), `{"email":"`+email+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("resend: status %d", w.Code)
+	}
+	tok := waitForMailLink(t, outbox, "/verify-email")
+
+	verify := func() {
+		w := httptest.NewRecorder()
+		ShowVerifyEmail(opts)(w, httptest.NewRequest("GET", "/verify-email?token="+url.QueryEscape(tok), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("verify: status %d", w.Code)
+		}
+	}
+	verify()
+
+	var verified bool
+	if err := db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified); err != nil {
+		t.Fatalf("query user: %v", err)
+	}
+	if !verified {
+		t.Fatal("email was not marked as verified")
+	}
+
+	// 已验证的邮箱不再发送验证邮件
+	post(ResendVerification(opts), `{"email":"`+email+`"}`)
+	time.Sleep(100 * time.Millisecond)
+	if files, _ := filepath.Glob(filepath.Join(outbox, "*.eml")); len(files) != 0 {
+		t.Fatalf("verification mail sent to a verified address")
+	}
+}
+
+func TestResetPasswordFlow(t *testing.T) {
+	db := setupTestDB(t)
+	opts, outbox := newTestAccountOptions(t, db)
+	userID, email := createUnverifiedUser(t, db, "old password")
+
+	h := hub.NewHub()
+	go h.Run()
+
+	if w := post(ForgotPassword(opts), `{"email":"`+email+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("forgot: status %d", w.Code)
+	}
+	// 未注册的邮箱返回相同的结果，但不发送邮件
+	if w := post(ForgotPassword(opts), `{"email":"nobody@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("forgot unknown email: status %d", w.Code)
+	}
+	tok := waitForMailLink(t, outbox, "/reset-password")
+
+	body := `{"token":"` + tok + `","password":"new password"}`
+	if w := post(ResetPassword(h, opts), body); w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", w.Code, w.Body)
+	}
+
+	var hash string
+	var verified bool
+	if err := db.QueryRow(
+		"SELECT password_hash, email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+	).Scan(&hash, &verified); err != nil {
+		t.Fatalf("query user: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("new password")) != nil {
+		t.Fatal("password was not changed")
+	}
+	if !verified {
+		t.Fatal("email was not marked as verified after reset")
+	}
+
+	// 令牌只能使用一次
+	if w := post(ResetPassword(h, opts), `{"token":"`+tok+`","password":"another"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("reusing token: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if files, _ := filepath.Glob(filepath.Join(outbox, "*.eml")); len(files) != 0 {
+		t.Fatalf("%d unexpected mails in outbox", len(files))
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultAuditLimit / maxAuditLimit 登录审计记录每次返回的默认和最大条数
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+// requireAdmin 检查当前用户是否是管理员，不是时写入错误响应并返回 false
+func requireAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	var isAdmin bool
+	err := database.DB.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error checking admin: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !isAdmin {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+// GetLoginAudit 获取最近的登录审计记录，可以用 username 参数按用户名筛选（仅管理员）
+func GetLoginAudit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	query := "SELECT id, user_id, username, ip, event, actor_id, created_at FROM login_audit"
+	args := []interface{}{limit}
+	if username := r.URL.Query().Get("username"); username != "" {
+		query += " WHERE LOWER(username) = $2"
+		args = append(args, throttleKey(username))
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT $1"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("Error querying login audit: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.LoginAuditEntry{}
+	for rows.Next() {
+		var entry models.LoginAuditEntry
+		var userID, actorID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &userID, &entry.Username, &entry.IP, &entry.Event, &actorID, &entry.CreatedAt); err != nil {
+			log.Printf("Error scanning login audit entry: %v", err)
+			continue
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			entry.UserID = &id
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			entry.ActorID = &id
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// UnlockUser 清除用户的登录失败记录，解除锁定（仅管理员）
+func UnlockUser(t *LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		targetID, err := strconv.Atoi(vars["userId"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		adminID, ok := requireAdmin(w, r)
+		if !ok {
+			return
+		}
+
+		var username string
+		err = database.DB.QueryRow("SELECT username FROM users WHERE id = $1", targetID).Scan(&username)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := t.Users.Reset(throttleKey(username)); err != nil {
+			log.Printf("Error unlocking user %d: %v", targetID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		recordLoginEvent(targetID, throttleKey(username), middleware.ClientIP(r), "unlock", adminID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "User unlocked",
+		})
+	}
+}
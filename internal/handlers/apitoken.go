@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/services/apitoken"
+	"go-chat/internal/services/hub"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxTokenNameLength 令牌名称的最大长度
+	maxTokenNameLength = 100
+	// maxTokenLifetimeDays 令牌的最长有效期
+	maxTokenLifetimeDays = 365
+	// maxBotsPerUser 每个用户最多创建的机器人数量
+	maxBotsPerUser = 10
+)
+
+// ShowTokensPage 显示 API 令牌和机器人管理页面
+func ShowTokensPage(w http.ResponseWriter, r *http.Request) {
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Username string
+	}{
+		Username: username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/tokens.html"))
+	tmpl.Execute(w, data)
+}
+
+// GetAPITokens 获取当前用户创建的未撤销的令牌（包括其机器人的令牌）
+func GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := middleware.TokenStore().List(userID)
+	if err != nil {
+		log.Printf("Error querying API tokens: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPIToken 为自己或自己的机器人创建令牌，令牌本身只在响应中返回这一次
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxTokenNameLength {
+		http.Error(w, "Token name must be 1-100 characters", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !apitoken.ValidScope(scope) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetimeDays {
+		http.Error(w, "expires_in_days must be between 0 and 365", http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	// 只能为自己拥有的机器人创建令牌
+	tokenUserID := userID
+	if req.BotID != 0 {
+		var exists bool
+		err := database.DB.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2)",
+			req.BotID, userID,
+		).Scan(&exists)
+		if err != nil {
+			log.Printf("Error checking bot owner: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Bot not found", http.StatusNotFound)
+			return
+		}
+		tokenUserID = req.BotID
+	}
+
+	raw, token, err := middleware.TokenStore().Create(tokenUserID, userID, req.Name, scopes, expiresAt)
+	if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"token":     raw,
+		"api_token": token,
+		"message":   "Token created, it will not be shown again",
+	})
+}
+
+// RevokeAPIToken 撤销令牌，并断开使用该令牌建立的 WebSocket 连接
+func RevokeAPIToken(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tokenID, err := strconv.Atoi(vars["tokenId"])
+		if err != nil {
+			http.Error(w, "Invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		err = middleware.TokenStore().Revoke(tokenID, userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error revoking API token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.DisconnectSession(middleware.TokenCredentialID(tokenID), models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "token revoked")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Token revoked",
+		})
+	}
+}
+
+// GetBots 获取当前用户创建的机器人
+func GetBots(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := database.DB.Query(
+		"SELECT id, username, created_at FROM users WHERE is_bot AND bot_owner_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		log.Printf("Error querying bots: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	bots := []models.Bot{}
+	for rows.Next() {
+		var bot models.Bot
+		if err := rows.Scan(&bot.ID, &bot.Username, &bot.CreatedAt); err != nil {
+			log.Printf("Error scanning bot: %v", err)
+			continue
+		}
+		bots = append(bots, bot)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
+
+// CreateBot 创建机器人账号
+// 机器人没有密码和邮箱，不能登录页面，只能通过其所有者为它创建的令牌访问；可以像普通用户一样被邀请进房间
+func CreateBot(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || utf8.RuneCountInString(req.Username) > maxUsernameLength {
+		http.Error(w, "Bot username must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE is_bot AND bot_owner_id = $1", userID,
+	).Scan(&count); err != nil {
+		log.Printf("Error counting bots: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxBotsPerUser {
+		http.Error(w, "Bot limit reached", http.StatusConflict)
+		return
+	}
+
+	var bot models.Bot
+	err := database.DB.QueryRow(`
+		INSERT INTO users (username, email, password_hash, is_bot, bot_owner_id)
+		VALUES ($1, NULL, '', TRUE, $2)
+		ON CONFLICT (username) DO NOTHING
+		RETURNING id, username, created_at
+	`, req.Username, userID).Scan(&bot.ID, &bot.Username, &bot.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Username already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error creating bot: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"bot":     bot,
+		"message": "Bot created",
+	})
+}
+
+// DeleteBot 删除机器人及其令牌，并断开它的全部连接
+// 机器人创建过房间时拒绝删除，否则这些房间会随账号一起被删除
+func DeleteBot(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		botID, err := strconv.Atoi(vars["botId"])
+		if err != nil {
+			http.Error(w, "Invalid bot ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var ownsRooms bool
+		err = database.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM rooms WHERE creator_id = u.id)
+			FROM users u WHERE u.id = $1 AND u.is_bot AND u.bot_owner_id = $2
+		`, botID, userID).Scan(&ownsRooms)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Bot not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying bot: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if ownsRooms {
+			http.Error(w, "Bot still owns rooms, delete or transfer them first", http.StatusConflict)
+			return
+		}
+
+		if _, err := database.DB.Exec(
+			"DELETE FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2", botID, userID,
+		); err != nil {
+			log.Printf("Error deleting bot: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.DisconnectUserEverywhere(botID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "bot deleted")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Bot deleted",
+		})
+	}
+}
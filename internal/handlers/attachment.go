@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/services/storage"
+	"go-chat/internal/services/thumbnail"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// defaultMaxAttachmentSize 默认单个附件大小上限（10MB），可通过 ATTACHMENT_MAX_SIZE 修改
+	defaultMaxAttachmentSize = 10 << 20
+
+	// maxAttachmentsPerMessage 每条消息最多关联的附件数
+	maxAttachmentsPerMessage = 10
+
+	// thumbnailSize 缩略图长边像素
+	thumbnailSize = 320
+)
+
+// allowedAttachmentTypes 允许上传的 MIME 类型（按文件内容嗅探，不信任客户端声明）
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":          true,
+	"image/jpeg":         true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"text/plain":         true,
+	"application/pdf":    true,
+	"application/zip":    true,
+	"application/x-gzip": true,
+}
+
+// thumbnailTypes 可以生成缩略图的图片类型
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+var errAttachmentInvalid = errors.New("Invalid attachment")
+
+// maxAttachmentSize 获取单个附件大小上限
+func maxAttachmentSize() int64 {
+	if value := os.Getenv("ATTACHMENT_MAX_SIZE"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxAttachmentSize
+}
+
+// attachmentColumns 查询附件时使用的列（attachments a），需与 scanAttachment 保持一致
+const attachmentColumns = `a.id, a.room_id, COALESCE(a.message_id, 0), a.uploader_id, a.filename,
+	a.content_type, a.size, a.thumbnail_key IS NOT NULL, COALESCE(a.width, 0), COALESCE(a.height, 0), a.created_at`
+
+// scanAttachment 按 attachmentColumns 的顺序扫描一个附件，并填充下载地址
+func scanAttachment(row rowScanner, a *models.Attachment) error {
+	var hasThumbnail bool
+	if err := row.Scan(&a.ID, &a.RoomID, &a.MessageID, &a.UploaderID, &a.Filename,
+		&a.ContentType, &a.Size, &hasThumbnail, &a.Width, &a.Height, &a.CreatedAt); err != nil {
+		return err
+	}
+
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	if hasThumbnail {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+	return nil
+}
+
+// linkAttachments 在保存消息的事务中将已上传的附件关联到消息
+// 只能关联自己在同一房间上传、且尚未使用的附件
+func linkAttachments(tx *sql.Tx, msg *models.Message) error {
+	ids := make(map[int]bool)
+	for _, id := range msg.AttachmentIDs {
+		ids[id] = true
+	}
+	if len(ids) > maxAttachmentsPerMessage {
+		return errAttachmentInvalid
+	}
+
+	rows, err := tx.Query(`
+		UPDATE attachments a SET message_id = $1
+		WHERE a.id = ANY($2) AND a.room_id = $3 AND a.uploader_id = $4 AND a.message_id IS NULL
+		RETURNING `+attachmentColumns,
+		msg.ID, pq.Array(msg.AttachmentIDs), msg.RoomID, msg.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	msg.Attachments = nil
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return err
+		}
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(msg.Attachments) != len(ids) {
+		return errAttachmentInvalid
+	}
+	return nil
+}
+
+// attachAttachments 为一组消息填充附件
+func attachAttachments(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(messages))
+	index := make(map[int]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+		index[messages[i].ID] = i
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		WHERE a.message_id = ANY($1)
+		ORDER BY a.id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return err
+		}
+		if i, ok := index[a.MessageID]; ok {
+			messages[i].Attachments = append(messages[i].Attachments, a)
+		}
+	}
+	return rows.Err()
+}
+
+// attachMessageDetails 为一组消息填充表情回应和附件
+func attachMessageDetails(messages []models.Message) error {
+	if err := attachReactions(messages); err != nil {
+		return err
+	}
+	return attachAttachments(messages)
+}
+
+// newStorageKey 为附件生成随机存储 key
+func newStorageKey(roomID int) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rooms/%d/%s", roomID, hex.EncodeToString(buf)), nil
+}
+
+// sanitizeFilename 只保留文件名部分并限制长度
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	if runes := []rune(name); len(runes) > 200 {
+		name = string(runes[:200])
+	}
+	return name
+}
+
+// UploadAttachment 上传附件，返回的附件 ID 在发送消息时通过 attachment_ids 关联
+func UploadAttachment(store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 检查用户是否是房间成员
+		var exists bool
+		err = database.DB.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+			roomID, userID,
+		).Scan(&exists)
+
+		if err != nil || !exists {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		// 归档的房间不能再上传附件
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		// 限制请求体大小，为 multipart 头部预留 1MB
+		maxSize := maxAttachmentSize()
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			http.Error(w, "File too large or invalid form", http.StatusRequestEntityTooLarge)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "File is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if header.Size > maxSize {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// 根据文件内容判断类型
+		sniff := make([]byte, 512)
+		n, err := io.ReadFull(file, sniff)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			http.Error(w, "Invalid file", http.StatusBadRequest)
+			return
+		}
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+		if !allowedAttachmentTypes[contentType] {
+			http.Error(w, "File type not allowed", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "Invalid file", http.StatusBadRequest)
+			return
+		}
+
+		key, err := newStorageKey(roomID)
+		if err != nil {
+			log.Printf("Error generating storage key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := store.Put(r.Context(), key, file, header.Size, contentType); err != nil {
+			log.Printf("Error storing attachment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 为图片生成缩略图，失败时不影响上传
+		var thumbKey sql.NullString
+		var width, height sql.NullInt64
+		if thumbnailTypes[contentType] {
+			if _, err := file.Seek(0, io.SeekStart); err == nil {
+				thumb, err := thumbnail.Generate(file, thumbnailSize)
+				if err != nil {
+					log.Printf("Error generating thumbnail: %v", err)
+				} else if err := store.Put(r.Context(), key+"_thumb", bytes.NewReader(thumb.Data),
+					int64(len(thumb.Data)), thumb.ContentType); err != nil {
+					log.Printf("Error storing thumbnail: %v", err)
+				} else {
+					thumbKey = sql.NullString{String: key + "_thumb", Valid: true}
+					width = sql.NullInt64{Int64: int64(thumb.Width), Valid: true}
+					height = sql.NullInt64{Int64: int64(thumb.Height), Valid: true}
+				}
+			}
+		}
+
+		var attachment models.Attachment
+		err = scanAttachment(database.DB.QueryRow(`
+			INSERT INTO attachments AS a (room_id, uploader_id, filename, content_type, size, storage_key, thumbnail_key, width, height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING `+attachmentColumns,
+			roomID, userID, sanitizeFilename(header.Filename), contentType, header.Size, key, thumbKey, width, height,
+		), &attachment)
+
+		if err != nil {
+			log.Printf("Error saving attachment: %v", err)
+			store.Delete(r.Context(), key)
+			if thumbKey.Valid {
+				store.Delete(r.Context(), thumbKey.String)
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attachment)
+	}
+}
+
+// DownloadAttachment 下载附件或其缩略图
+// 只有房间成员可以下载；尚未发送的附件只有上传者可见，所属消息被删除后不可再下载
+func DownloadAttachment(store storage.Store, thumb bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		attachmentID, err := strconv.Atoi(vars["attachmentId"])
+		if err != nil {
+			http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r)
+
+		var filename, contentType, storageKey string
+		var thumbKey sql.NullString
+		err = database.DB.QueryRow(`
+			SELECT a.filename, a.content_type, a.storage_key, a.thumbnail_key
+			FROM attachments a
+			WHERE a.id = $1
+			  AND EXISTS (SELECT 1 FROM room_members rm WHERE rm.room_id = a.room_id AND rm.user_id = $2)
+			  AND (a.message_id IS NOT NULL OR a.uploader_id = $2)
+			  AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.id = a.message_id AND m.deleted_at IS NOT NULL)
+		`, attachmentID, userID).Scan(&filename, &contentType, &storageKey, &thumbKey)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying attachment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		key := storageKey
+		if thumb {
+			if !thumbKey.Valid {
+				http.Error(w, "Thumbnail not found", http.StatusNotFound)
+				return
+			}
+			key = thumbKey.String
+			// 缩略图与原图格式的对应关系见 thumbnail.Generate
+			if contentType != "image/jpeg" {
+				contentType = "image/png"
+			}
+		}
+
+		rc, err := store.Open(r.Context(), key)
+		if err == storage.ErrNotFound {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error opening attachment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+
+		// 只有图片在浏览器内直接显示，其他类型一律作为下载
+		disposition := "attachment"
+		if strings.HasPrefix(contentType, "image/") {
+			disposition = "inline"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		io.Copy(w, rc)
+	}
+}
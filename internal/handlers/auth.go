@@ -4,149 +4,286 @@ import (
 	"database/sql"
 	"encoding/json"
 	"go-chat/internal/database"
+	"go-chat/internal/middleware"
 	"go-chat/internal/models"
+	"go-chat/internal/services/hub"
+	"go-chat/internal/services/oidc"
 	"html/template"
 	"log"
 	"net/http"
+	"net/mail"
 
-	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var store = sessions.NewCookieStore([]byte("your-secret-key-change-this-in-production"))
-
 // ShowRegisterPage 显示注册页面
 func ShowRegisterPage(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFiles("web/templates/register.html"))
 	tmpl.Execute(w, nil)
 }
 
-// ShowLoginPage 显示登录页面
-func ShowLoginPage(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("web/templates/login.html"))
-	tmpl.Execute(w, nil)
+// ShowLoginPage 显示登录页面，配置了单点登录时同时显示各身份提供方的登录按钮
+func ShowLoginPage(providers []*oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderLoginPage(w, providers, "", http.StatusOK)
+	}
 }
 
-// Register 处理用户注册
-func Register(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// renderLoginPage 渲染登录页面，message 不为空时显示为错误提示
+func renderLoginPage(w http.ResponseWriter, providers []*oidc.Provider, message string, status int) {
+	data := struct {
+		Providers []*oidc.Provider
+		Error     string
+	}{
+		Providers: providers,
+		Error:     message,
 	}
 
-	var req models.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+	tmpl := template.Must(template.ParseFiles("web/templates/login.html"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
 
-	// 验证输入
-	if req.Username == "" || req.Email == "" || req.Password == "" {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
-		return
-	}
+// Register 处理用户注册，注册成功后发送邮箱验证邮件
+func Register(opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	// 密码加密
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Printf("Error hashing password: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+		var req models.RegisterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	// 插入用户到数据库
-	var userID int
-	err = database.DB.QueryRow(
-		"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id",
-		req.Username, req.Email, string(hashedPassword),
-	).Scan(&userID)
+		// 验证输入
+		if req.Username == "" || req.Email == "" || req.Password == "" {
+			http.Error(w, "All fields are required", http.StatusBadRequest)
+			return
+		}
+		if _, err := mail.ParseAddress(req.Email); err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
 
-	if err != nil {
-		log.Printf("Error creating user: %v", err)
-		http.Error(w, "Username or email already exists", http.StatusConflict)
-		return
-	}
+		// 密码加密
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"user_id": userID,
-		"message": "Registration successful",
-	})
-}
+		// 插入用户到数据库
+		var userID int
+		err = database.DB.QueryRow(
+			"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id",
+			req.Username, req.Email, string(hashedPassword),
+		).Scan(&userID)
+
+		if err != nil {
+			log.Printf("Error creating user: %v", err)
+			http.Error(w, "Username or email already exists", http.StatusConflict)
+			return
+		}
+
+		if err := opts.sendVerification(userID, req.Username, req.Email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
 
-// Login 处理用户登录
-func Login(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":               true,
+			"user_id":               userID,
+			"verification_required": opts.RequireEmailVerification,
+			"message":               "Registration successful",
+		})
 	}
+}
+
+// Login 处理用户登录；开启 RequireEmailVerification 时邮箱未验证的用户不能登录
+func Login(opts *AccountOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req models.LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// 用户名或 IP 连续失败过多时需要等待，锁定期间密码正确也不能登录
+		attempt, wait, err := opts.Throttle.begin(req.Username, middleware.ClientIP(r))
+		if err != nil {
+			log.Printf("Error checking login throttle: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
 
-	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		// 查询用户
+		var user models.User
+		var twoFactor bool
+		err = database.DB.QueryRow(
+			"SELECT id, username, COALESCE(email, ''), password_hash, email_verified_at, totp_enabled_at IS NOT NULL FROM users WHERE username = $1",
+			req.Username,
+		).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.EmailVerifiedAt, &twoFactor)
+
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 验证密码；用户不存在或没有密码（机器人、单点登录创建的账号）时也做一次同样耗时的比较，
+		// 避免通过响应时间判断用户名是否存在
+		hash := []byte(user.PasswordHash)
+		exists := err == nil && user.PasswordHash != ""
+		if !exists {
+			hash = dummyPasswordHash()
+		}
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !exists {
+			opts.Throttle.fail(attempt, user.ID)
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		// 开启两步验证时失败记录保留到第二步完成，只知道密码不能清除计数
+		if twoFactor {
+			opts.Throttle.pass(attempt)
+		} else {
+			opts.Throttle.succeed(attempt)
+		}
+
+		// 密码正确后才提示邮箱未验证，避免泄露用户名是否存在
+		if opts.RequireEmailVerification && user.EmailVerifiedAt == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":          false,
+				"email_unverified": true,
+				"message":          "Please verify your email address before logging in",
+			})
+			return
+		}
+
+		if err := startSession(w, r, user.ID, user.Username, twoFactor); err != nil {
+			log.Printf("Error saving session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		message := "Login successful"
+		if twoFactor {
+			message = "Two-factor authentication code required"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"user_id":      user.ID,
+			"username":     user.Username,
+			"mfa_required": twoFactor,
+			"message":      message,
+		})
 	}
+}
 
-	// 查询用户
-	var user models.User
-	err := database.DB.QueryRow(
-		"SELECT id, username, email, password_hash FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-		return
-	} else if err != nil {
-		log.Printf("Error querying user: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+// startSession 为通过身份验证的用户创建新的 session
+// 登录前已有的 session 作废并更换 ID，防止会话固定攻击；twoFactor 为 true 时 session 还需要完成两步验证
+func startSession(w http.ResponseWriter, r *http.Request, userID int, username string, twoFactor bool) error {
+	session, _ := middleware.GetSession(r)
+	if !session.IsNew {
+		if err := middleware.SessionStore().Revoke(session.ID); err != nil {
+			log.Printf("Error revoking previous session: %v", err)
+		}
+		session.ID = ""
+	}
+	// 旧 session 的数据（如未完成的两步验证状态）不带入新的登录
+	session.Values = make(map[interface{}]interface{})
+	session.Values["user_id"] = userID
+	session.Values["username"] = username
+	// 开启两步验证时还需要在 /login/2fa 输入验证码，完成前 session 不算登录
+	if twoFactor {
+		middleware.BeginMFA(session)
+	}
+	if err := session.Save(r, w); err != nil {
+		return err
 	}
 
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-		return
+	if err := middleware.SessionStore().Touch(session.ID, middleware.ClientIP(r), r.UserAgent()); err != nil {
+		log.Printf("Error touching session: %v", err)
 	}
+	return nil
+}
+
+// Logout 处理用户登出，删除服务端保存的 session 并断开该 session 的 WebSocket 连接
+func Logout(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := middleware.GetSession(r)
+		sessionID := session.ID
+		session.Options.MaxAge = -1
+		if err := session.Save(r, w); err != nil {
+			log.Printf("Error deleting session: %v", err)
+		}
 
-	// 创建 session
-	session, _ := store.Get(r, "session")
-	session.Values["user_id"] = user.ID
-	session.Values["username"] = user.Username
-	session.Save(r, w)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":  true,
-		"user_id":  user.ID,
-		"username": user.Username,
-		"message":  "Login successful",
-	})
+		// 同一浏览器中已打开的聊天室连接随之断开
+		if sessionID != "" {
+			h.DisconnectSession(sessionID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "logged out")
+		}
+
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
 }
 
-// Logout 处理用户登出
-func Logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session")
-	session.Values["user_id"] = nil
-	session.Values["username"] = nil
-	session.Options.MaxAge = -1
-	session.Save(r, w)
+// LogoutEverywhere 在所有设备上退出登录：删除用户的全部 session 并断开其 WebSocket 连接
+func LogoutEverywhere(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if err := middleware.SessionStore().RevokeUser(userID); err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.DisconnectUserEverywhere(userID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "logged out")
+
+		// 当前请求的 session 已被删除，同时清除 cookie
+		session, _ := middleware.GetSession(r)
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Logged out on all devices",
+		})
+	}
 }
 
 // GetCurrentUser 获取当前登录用户
 func GetCurrentUser(r *http.Request) (*models.User, error) {
-	session, _ := store.Get(r, "session")
-	userID, ok := session.Values["user_id"].(int)
+	userID, ok := middleware.GetUserID(r)
 	if !ok {
 		return nil, sql.ErrNoRows
 	}
 
 	var user models.User
 	err := database.DB.QueryRow(
-		"SELECT id, username, email FROM users WHERE id = $1",
+		"SELECT id, username, COALESCE(email, '') FROM users WHERE id = $1",
 		userID,
 	).Scan(&user.ID, &user.Username, &user.Email)
 
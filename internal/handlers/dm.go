@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maxGroupDMMembers 多人私聊的成员上限（含发起者）
+const maxGroupDMMembers = 9
+
+// roomNameSQL 返回房间显示名称的 SQL 表达式（rooms 表别名为 r）
+// 私聊房间没有名称，显示为除当前用户（userParam）外其他成员的用户名
+func roomNameSQL(userParam string) string {
+	return `CASE WHEN r.kind = '` + models.RoomKindChannel + `' THEN r.name ELSE COALESCE((
+		SELECT string_agg(du.username, ', ' ORDER BY du.username)
+		FROM room_members drm
+		INNER JOIN users du ON drm.user_id = du.id
+		WHERE drm.room_id = r.id AND drm.user_id <> ` + userParam + `
+	), r.name) END`
+}
+
+// getRoomKind 获取房间类型
+func getRoomKind(roomID int) (string, error) {
+	var kind string
+	err := database.DB.QueryRow("SELECT kind FROM rooms WHERE id = $1", roomID).Scan(&kind)
+	return kind, err
+}
+
+// dmKey 一对一私聊的唯一键，与用户顺序无关
+func dmKey(a, b int) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// OpenDM 打开与其他用户的私聊
+// 一对一私聊是幂等的：两人之间已有私聊时直接返回该房间；多人私聊每次都会新建
+func OpenDM(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.OpenDMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// 去重，并去掉自己
+	username, _ := middleware.GetUsername(r)
+	seen := map[string]bool{username: true}
+	var usernames []string
+	for _, name := range append(req.Usernames, req.Username) {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+
+	if len(usernames) == 0 {
+		http.Error(w, "At least one other user is required", http.StatusBadRequest)
+		return
+	}
+	if len(usernames)+1 > maxGroupDMMembers {
+		http.Error(w, fmt.Sprintf("A group DM can have at most %d members", maxGroupDMMembers), http.StatusBadRequest)
+		return
+	}
+
+	// 查找对方用户
+	rows, err := database.DB.Query("SELECT id FROM users WHERE username = ANY($1)", pq.Array(usernames))
+	if err != nil {
+		log.Printf("Error finding users: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var memberIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Error scanning user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		memberIDs = append(memberIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error finding users: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(memberIDs) != len(usernames) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var roomID int
+	var created bool
+	if len(memberIDs) == 1 {
+		roomID, created, err = openDirectMessage(userID, memberIDs[0])
+	} else {
+		roomID, err = createGroupDM(userID, memberIDs)
+		created = true
+	}
+
+	if err != nil {
+		log.Printf("Error opening DM: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"room_id": roomID,
+		"created": created,
+	})
+}
+
+// openDirectMessage 查找或创建两人之间的私聊房间
+// 通过 dm_key 唯一索引保证并发打开时也只会创建一个房间
+func openDirectMessage(userID, otherID int) (int, bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	key := dmKey(userID, otherID)
+	created := true
+
+	var roomID int
+	err = tx.QueryRow(`
+		INSERT INTO rooms (name, description, creator_id, kind, dm_key)
+		VALUES ('', '', $1, $2, $3)
+		ON CONFLICT (dm_key) DO NOTHING
+		RETURNING id
+	`, userID, models.RoomKindDM, key).Scan(&roomID)
+
+	if err == sql.ErrNoRows {
+		created = false
+		err = tx.QueryRow("SELECT id FROM rooms WHERE dm_key = $1", key).Scan(&roomID)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	// 私聊双方都是普通成员，任何一方都不能邀请或移除成员
+	for _, id := range []int{userID, otherID} {
+		if _, err = tx.Exec(
+			"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (room_id, user_id) DO NOTHING",
+			roomID, id, permissions.RoleMember,
+		); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return roomID, created, tx.Commit()
+}
+
+// createGroupDM 创建多人私聊，发起者为房间创建者
+func createGroupDM(userID int, memberIDs []int) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	if err = tx.QueryRow(
+		"INSERT INTO rooms (name, description, creator_id, kind) VALUES ('', '', $1, $2) RETURNING id",
+		userID, models.RoomKindGroupDM,
+	).Scan(&roomID); err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)",
+		roomID, userID, permissions.RoleCreator,
+	); err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.Exec(`
+		INSERT INTO room_members (room_id, user_id, role)
+		SELECT $1, unnest($2::int[]), $3
+	`, roomID, pq.Array(memberIDs), permissions.RoleMember); err != nil {
+		return 0, err
+	}
+
+	return roomID, tx.Commit()
+}
@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/events"
+	"go-chat/internal/services/hub"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxEventSubscriptionsPerRoom 每个房间最多的事件订阅数
+	maxEventSubscriptionsPerRoom = 10
+	// defaultDeliveryPageSize / maxDeliveryPageSize 投递日志每页的默认和最大条数
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 200
+)
+
+// eventSubscriptionColumns 事件订阅查询的列（room_event_subscriptions 别名为 s，users 别名为 u）
+const eventSubscriptionColumns = `s.id, s.room_id, s.url, s.events, s.created_by, u.username, s.created_at`
+
+// scanEventSubscription 扫描一行 eventSubscriptionColumns
+func scanEventSubscription(row rowScanner, sub *models.EventSubscription) error {
+	return row.Scan(
+		&sub.ID, &sub.RoomID, &sub.URL, pq.Array(&sub.Events), &sub.CreatedBy, &sub.CreatorName, &sub.CreatedAt,
+	)
+}
+
+// emitMemberEvent 在事务 e 中发出 member.joined 或 member.removed 事件
+func emitMemberEvent(h *hub.Hub, e hub.Execer, roomID int, eventType string, userID int, username string, actorID int, reason string) error {
+	return h.Emit(e, roomID, eventType, models.MemberEvent{
+		UserID:   userID,
+		Username: username,
+		ActorID:  actorID,
+		Reason:   reason,
+	})
+}
+
+// parseSubscriptionVars 解析路由中的房间 ID 和订阅 ID
+func parseSubscriptionVars(r *http.Request) (roomID, subscriptionID int, err error) {
+	vars := mux.Vars(r)
+	if roomID, err = strconv.Atoi(vars["id"]); err != nil {
+		return 0, 0, err
+	}
+	if subscriptionID, err = strconv.Atoi(vars["subscriptionId"]); err != nil {
+		return 0, 0, err
+	}
+	return roomID, subscriptionID, nil
+}
+
+// checkSubscription 检查当前用户能否管理房间的事件订阅，且订阅属于该房间，否则写入错误响应并返回 false
+func checkSubscription(w http.ResponseWriter, r *http.Request, roomID, subscriptionID int) bool {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageEventSubs); !ok {
+		return false
+	}
+
+	var exists bool
+	if err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_event_subscriptions WHERE id = $1 AND room_id = $2)",
+		subscriptionID, roomID,
+	).Scan(&exists); err != nil {
+		log.Printf("Error querying event subscription: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ShowEventSubscriptionsPage 显示房间的事件订阅和投递日志页面（需要 ManageEventSubs 权限）
+func ShowEventSubscriptionsPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r)
+	role, err := getMemberRole(database.DB, roomID, userID)
+	if err != nil || !permissions.Can(role, permissions.ManageEventSubs) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var room models.Room
+	err = database.DB.QueryRow(
+		"SELECT id, name, kind FROM rooms WHERE id = $1", roomID,
+	).Scan(&room.ID, &room.Name, &room.Kind)
+	if err != nil {
+		log.Printf("Error querying room: %v", err)
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if room.Kind != models.RoomKindChannel {
+		http.Error(w, "Event subscriptions are only available in channels", http.StatusForbidden)
+		return
+	}
+
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Room       models.Room
+		EventTypes []string
+		Username   string
+	}{
+		Room:       room,
+		EventTypes: events.Types,
+		Username:   username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/event_subscriptions.html"))
+	tmpl.Execute(w, data)
+}
+
+// GetEventSubscriptions 获取房间的事件订阅（需要 ManageEventSubs 权限）
+func GetEventSubscriptions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageEventSubs); !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+eventSubscriptionColumns+`
+		FROM room_event_subscriptions s INNER JOIN users u ON s.created_by = u.id
+		WHERE s.room_id = $1
+		ORDER BY s.created_at
+	`, roomID)
+	if err != nil {
+		log.Printf("Error querying event subscriptions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subs := []models.EventSubscription{}
+	for rows.Next() {
+		var sub models.EventSubscription
+		if err := scanEventSubscription(rows, &sub); err != nil {
+			log.Printf("Error scanning event subscription: %v", err)
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// CreateEventSubscription 创建事件订阅（需要 ManageEventSubs 权限），签名密钥只在响应中返回这一次
+// 订阅以创建者的身份接收房间内容，创建者离开房间或失去权限后不再投递
+func CreateEventSubscription(d *events.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageEventSubs); !ok {
+			return
+		}
+
+		kind, err := getRoomKind(roomID)
+		if err != nil {
+			log.Printf("Error querying room kind: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if kind != models.RoomKindChannel {
+			http.Error(w, "Event subscriptions are only available in channels", http.StatusForbidden)
+			return
+		}
+
+		var req models.CreateEventSubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req.URL = strings.TrimSpace(req.URL)
+		if err := d.ValidateURL(req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Events) == 0 {
+			http.Error(w, "At least one event is required", http.StatusBadRequest)
+			return
+		}
+		eventTypes := make([]string, 0, len(req.Events))
+		seen := make(map[string]bool)
+		for _, eventType := range req.Events {
+			if !events.ValidType(eventType) {
+				http.Error(w, "Unknown event: "+eventType, http.StatusBadRequest)
+				return
+			}
+			if !seen[eventType] {
+				seen[eventType] = true
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+
+		var count int
+		if err := database.DB.QueryRow(
+			"SELECT COUNT(*) FROM room_event_subscriptions WHERE room_id = $1", roomID,
+		).Scan(&count); err != nil {
+			log.Printf("Error counting event subscriptions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxEventSubscriptionsPerRoom {
+			http.Error(w, "Subscription limit reached", http.StatusConflict)
+			return
+		}
+
+		secret, err := events.NewSecret()
+		if err != nil {
+			log.Printf("Error generating subscription secret: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var sub models.EventSubscription
+		err = scanEventSubscription(database.DB.QueryRow(`
+			WITH s AS (
+				INSERT INTO room_event_subscriptions (room_id, created_by, url, secret, events)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING *
+			)
+			SELECT `+eventSubscriptionColumns+`
+			FROM s INNER JOIN users u ON s.created_by = u.id
+		`, roomID, userID, req.URL, secret, pq.Array(eventTypes)), &sub)
+		if err != nil {
+			log.Printf("Error creating event subscription: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"subscription": sub,
+			"secret":       secret,
+			"message":      "Subscription created, the secret will not be shown again",
+		})
+	}
+}
+
+// DeleteEventSubscription 删除事件订阅及其投递记录（需要 ManageEventSubs 权限）
+func DeleteEventSubscription(w http.ResponseWriter, r *http.Request) {
+	roomID, subscriptionID, err := parseSubscriptionVars(r)
+	if err != nil {
+		http.Error(w, "Invalid room or subscription ID", http.StatusBadRequest)
+		return
+	}
+
+	if !checkSubscription(w, r, roomID, subscriptionID) {
+		return
+	}
+
+	if _, err := database.DB.Exec(
+		"DELETE FROM room_event_subscriptions WHERE id = $1 AND room_id = $2", subscriptionID, roomID,
+	); err != nil {
+		log.Printf("Error deleting event subscription: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Subscription deleted",
+	})
+}
+
+// GetEventDeliveries 获取订阅的投递记录，按时间倒序（需要 ManageEventSubs 权限）
+// status 参数筛选状态，status=dead 即死信列表；before 为上一页最后一条的 ID
+func GetEventDeliveries(w http.ResponseWriter, r *http.Request) {
+	roomID, subscriptionID, err := parseSubscriptionVars(r)
+	if err != nil {
+		http.Error(w, "Invalid room or subscription ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != models.DeliveryPending && status != models.DeliverySucceeded && status != models.DeliveryDead {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	var before int64
+	if v := query.Get("before"); v != "" {
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil || before <= 0 {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultDeliveryPageSize
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxDeliveryPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !checkSubscription(w, r, roomID, subscriptionID) {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, subscription_id, event_id, event, payload, status, attempts,
+			CASE WHEN status = $5 THEN next_attempt_at END, last_status_code, COALESCE(last_error, ''),
+			delivered_at, created_at
+		FROM event_deliveries
+		WHERE subscription_id = $1 AND ($2 = '' OR status = $2) AND ($3::bigint = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`, subscriptionID, status, before, limit, models.DeliveryPending)
+	if err != nil {
+		log.Printf("Error querying event deliveries: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.EventDelivery{}
+	for rows.Next() {
+		var d models.EventDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt); err != nil {
+			log.Printf("Error scanning event delivery: %v", err)
+			continue
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// GetDeliveryAttempts 获取一次投递的全部尝试日志（需要 ManageEventSubs 权限）
+func GetDeliveryAttempts(w http.ResponseWriter, r *http.Request) {
+	roomID, subscriptionID, err := parseSubscriptionVars(r)
+	if err != nil {
+		http.Error(w, "Invalid room or subscription ID", http.StatusBadRequest)
+		return
+	}
+	deliveryID, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	if !checkSubscription(w, r, roomID, subscriptionID) {
+		return
+	}
+
+	var exists bool
+	if err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM event_deliveries WHERE id = $1 AND subscription_id = $2)",
+		deliveryID, subscriptionID,
+	).Scan(&exists); err != nil {
+		log.Printf("Error querying event delivery: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, status_code, COALESCE(error, ''), duration_ms, created_at
+		FROM event_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY id
+	`, deliveryID)
+	if err != nil {
+		log.Printf("Error querying delivery attempts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.DeliveryAttempt{}
+	for rows.Next() {
+		var a models.DeliveryAttempt
+		if err := rows.Scan(&a.ID, &a.StatusCode, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+			log.Printf("Error scanning delivery attempt: %v", err)
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// RedeliverEvent 把死信重新放回投递队列（需要 ManageEventSubs 权限）
+func RedeliverEvent(d *events.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, subscriptionID, err := parseSubscriptionVars(r)
+		if err != nil {
+			http.Error(w, "Invalid room or subscription ID", http.StatusBadRequest)
+			return
+		}
+		deliveryID, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+			return
+		}
+
+		if !checkSubscription(w, r, roomID, subscriptionID) {
+			return
+		}
+
+		requeued, err := d.Requeue(subscriptionID, deliveryID)
+		if err != nil {
+			log.Printf("Error requeueing event delivery: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !requeued {
+			http.Error(w, "Dead delivery not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Delivery queued",
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxInviteUses 邀请链接可设置的最大使用次数
+	maxInviteUses = 1000
+
+	// maxInviteExpiry 邀请链接可设置的最长有效期（秒），0 表示不过期
+	maxInviteExpiry = 30 * 24 * 60 * 60
+)
+
+// inviteColumns 邀请链接查询的列（room_invites 别名为 i，users 别名为 u）
+const inviteColumns = `i.id, i.room_id, i.token, i.created_by, u.username, i.max_uses, i.uses, i.expires_at, i.created_at`
+
+// activeInviteSQL 邀请链接仍然有效的条件
+const activeInviteSQL = `i.revoked_at IS NULL
+	AND (i.expires_at IS NULL OR i.expires_at > CURRENT_TIMESTAMP)
+	AND (i.max_uses IS NULL OR i.uses < i.max_uses)`
+
+// scanInvite 扫描一行 inviteColumns
+func scanInvite(row rowScanner, invite *models.RoomInvite) error {
+	if err := row.Scan(
+		&invite.ID, &invite.RoomID, &invite.Token, &invite.CreatedBy, &invite.CreatorName,
+		&invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.CreatedAt,
+	); err != nil {
+		return err
+	}
+	invite.URL = "/invite/" + invite.Token
+	return nil
+}
+
+// newInviteToken 生成随机的邀请 token
+func newInviteToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateInvite 创建邀请链接（需要 Invite 权限）
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.Invite); !ok {
+		return
+	}
+
+	// 一对一私聊的成员固定为两人
+	kind, err := getRoomKind(roomID)
+	if err != nil {
+		log.Printf("Error querying room kind: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if kind == models.RoomKindDM {
+		http.Error(w, "Cannot invite members to a direct message", http.StatusForbidden)
+		return
+	}
+
+	if err := checkRoomWritable(roomID); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	var req models.CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxUses < 0 || req.MaxUses > maxInviteUses {
+		http.Error(w, "Invalid max uses", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresIn < 0 || req.ExpiresIn > maxInviteExpiry {
+		http.Error(w, "Invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		log.Printf("Error generating invite token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// 0 表示不限制，存为 NULL
+	var invite models.RoomInvite
+	err = scanInvite(database.DB.QueryRow(`
+		WITH i AS (
+			INSERT INTO room_invites (room_id, token, created_by, max_uses, expires_at)
+			VALUES ($1, $2, $3, NULLIF($4, 0),
+			        CASE WHEN $5 > 0 THEN CURRENT_TIMESTAMP + make_interval(secs => $5) END)
+			RETURNING *
+		)
+		SELECT `+inviteColumns+`
+		FROM i INNER JOIN users u ON i.created_by = u.id
+	`, roomID, token, userID, req.MaxUses, req.ExpiresIn), &invite)
+
+	if err != nil {
+		log.Printf("Error creating invite: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// GetRoomInvites 获取房间仍然有效的邀请链接（需要 Invite 权限）
+func GetRoomInvites(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.Invite); !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+inviteColumns+`
+		FROM room_invites i
+		INNER JOIN users u ON i.created_by = u.id
+		WHERE i.room_id = $1 AND `+activeInviteSQL+`
+		ORDER BY i.created_at DESC
+	`, roomID)
+
+	if err != nil {
+		log.Printf("Error querying invites: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invites := []models.RoomInvite{}
+	for rows.Next() {
+		var invite models.RoomInvite
+		if err := scanInvite(rows, &invite); err != nil {
+			log.Printf("Error scanning invite: %v", err)
+			continue
+		}
+		invites = append(invites, invite)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// RevokeInvite 撤销邀请链接（需要 Invite 权限）
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	inviteID, err := strconv.Atoi(vars["inviteId"])
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.Invite); !ok {
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"UPDATE room_invites SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL",
+		inviteID, roomID,
+	)
+	if err != nil {
+		log.Printf("Error revoking invite: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Invite revoked successfully",
+	})
+}
+
+// ShowInvite 显示邀请链接页面，由用户确认后加入房间
+// 链接无效时不显示房间信息
+func ShowInvite(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	userID, _ := middleware.GetUserID(r)
+
+	var roomID int
+	var roomName, roomDescription string
+	var joined bool
+	err := database.DB.QueryRow(`
+		SELECT r.id, `+roomNameSQL("$2")+`, r.description,
+		       EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $2)
+		FROM room_invites i
+		INNER JOIN rooms r ON i.room_id = r.id
+		WHERE i.token = $1 AND `+activeInviteSQL+`
+	`, token, userID).Scan(&roomID, &roomName, &roomDescription, &joined)
+
+	valid := true
+	if err == sql.ErrNoRows {
+		valid = false
+	} else if err != nil {
+		log.Printf("Error querying invite: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Token           string
+		Valid           bool
+		Joined          bool
+		RoomID          int
+		RoomName        string
+		RoomDescription string
+		Username        string
+	}{
+		Token:           token,
+		Valid:           valid,
+		Joined:          joined,
+		RoomID:          roomID,
+		RoomName:        roomName,
+		RoomDescription: roomDescription,
+		Username:        username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/invite.html"))
+	tmpl.Execute(w, data)
+}
+
+// AcceptInvite 通过邀请链接加入房间
+// 邀请行在事务中加锁，并发使用同一链接时依次计数，保证不超过使用次数上限；
+// 已是房间成员时不消耗次数
+func AcceptInvite(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var inviteID, roomID int
+		err = tx.QueryRow(`
+			SELECT i.id, i.room_id FROM room_invites i
+			WHERE i.token = $1 AND `+activeInviteSQL+`
+			FOR UPDATE
+		`, token).Scan(&inviteID, &roomID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invite link is invalid or has expired", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying invite: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		archived, err := isRoomArchived(roomID)
+		if err != nil {
+			log.Printf("Error checking room archive state: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if archived {
+			http.Error(w, "This room is archived", http.StatusForbidden)
+			return
+		}
+
+		joined, err := addRoomMember(tx, roomID, userID, permissions.RoleMember)
+		if err != nil {
+			log.Printf("Error joining room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if joined {
+			if _, err = tx.Exec("UPDATE room_invites SET uses = uses + 1 WHERE id = $1", inviteID); err != nil {
+				log.Printf("Error updating invite uses: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			username, _ := middleware.GetUsername(r)
+			if err = emitMemberEvent(h, tx, roomID, models.EventMemberJoined, userID, username, 0, "invite_link"); err != nil {
+				log.Printf("Error emitting member event: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		message := "Joined room successfully"
+		if !joined {
+			message = "Already a member of this room"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"room_id": roomID,
+			"message": message,
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// maxJoinRequestMessageLength 加入申请附言的最大长度
+const maxJoinRequestMessageLength = 500
+
+// joinRequestColumns 加入申请查询的列（room_join_requests 别名为 jr，users 别名为 u）
+const joinRequestColumns = `jr.id, jr.room_id, jr.user_id, u.username, jr.message, jr.status, jr.decided_by, jr.decided_at, jr.created_at`
+
+// scanJoinRequest 扫描一行 joinRequestColumns
+func scanJoinRequest(row rowScanner, req *models.JoinRequest) error {
+	return row.Scan(
+		&req.ID, &req.RoomID, &req.UserID, &req.Username, &req.Message,
+		&req.Status, &req.DecidedBy, &req.DecidedAt, &req.CreatedAt,
+	)
+}
+
+// joinRequestApprovers 返回房间内有权审批加入申请的成员 ID
+func joinRequestApprovers(roomID int) ([]int, error) {
+	var roles []string
+	for _, role := range permissions.RolesWith(permissions.ApproveJoinRequests) {
+		roles = append(roles, string(role))
+	}
+
+	rows, err := database.DB.Query(
+		"SELECT user_id FROM room_members WHERE room_id = $1 AND role = ANY($2)",
+		roomID, pq.Array(roles),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// notifyApprovers 把加入申请的变化推送给在线的审批人
+func notifyApprovers(h *hub.Hub, eventType string, req *models.JoinRequest) {
+	approvers, err := joinRequestApprovers(req.RoomID)
+	if err != nil {
+		log.Printf("Error querying join request approvers: %v", err)
+		return
+	}
+
+	h.SendToUsers(req.RoomID, approvers, models.WebSocketMessage{
+		Type:        eventType,
+		RoomID:      req.RoomID,
+		JoinRequest: req,
+	})
+}
+
+// CreateJoinRequest 申请加入需要审批的房间
+func CreateJoinRequest(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var body models.CreateJoinRequestRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		body.Message = strings.TrimSpace(body.Message)
+		if utf8.RuneCountInString(body.Message) > maxJoinRequestMessageLength {
+			http.Error(w, "Message is too long", http.StatusBadRequest)
+			return
+		}
+
+		// 私有房间与不存在的房间返回相同的错误，避免泄露私有房间
+		var visibility string
+		var archived, member bool
+		err = database.DB.QueryRow(`
+			SELECT r.visibility, r.archived_at IS NOT NULL,
+			       EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $2)
+			FROM rooms r
+			WHERE r.id = $1 AND r.kind = $3
+		`, roomID, userID, models.RoomKindChannel).Scan(&visibility, &archived, &member)
+
+		if err == sql.ErrNoRows || (err == nil && visibility == models.RoomVisibilityPrivate && !member) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if member {
+			http.Error(w, "You are already a member of this room", http.StatusConflict)
+			return
+		}
+		if archived {
+			http.Error(w, "This room is archived", http.StatusForbidden)
+			return
+		}
+		if visibility != models.RoomVisibilityRequest {
+			http.Error(w, "This room does not accept join requests", http.StatusBadRequest)
+			return
+		}
+
+		username, _ := middleware.GetUsername(r)
+		req := models.JoinRequest{
+			RoomID:   roomID,
+			UserID:   userID,
+			Username: username,
+			Message:  body.Message,
+			Status:   models.JoinRequestPending,
+		}
+
+		// 已有待审批的申请时不重复创建
+		err = database.DB.QueryRow(`
+			INSERT INTO room_join_requests (room_id, user_id, message, status)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (room_id, user_id) WHERE status = '`+models.JoinRequestPending+`' DO NOTHING
+			RETURNING id, created_at
+		`, roomID, userID, req.Message, req.Status).Scan(&req.ID, &req.CreatedAt)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "You already have a pending request for this room", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Printf("Error creating join request: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		notifyApprovers(h, "join_request", &req)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(req)
+	}
+}
+
+// GetJoinRequests 获取房间待审批的加入申请（需要 ApproveJoinRequests 权限）
+func GetJoinRequests(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.ApproveJoinRequests); !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+joinRequestColumns+`
+		FROM room_join_requests jr
+		INNER JOIN users u ON jr.user_id = u.id
+		WHERE jr.room_id = $1 AND jr.status = $2
+		ORDER BY jr.created_at
+	`, roomID, models.JoinRequestPending)
+
+	if err != nil {
+		log.Printf("Error querying join requests: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := []models.JoinRequest{}
+	for rows.Next() {
+		var req models.JoinRequest
+		if err := scanJoinRequest(rows, &req); err != nil {
+			log.Printf("Error scanning join request: %v", err)
+			continue
+		}
+		requests = append(requests, req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// DecideJoinRequest 通过（approve 为 true）或拒绝加入申请（需要 ApproveJoinRequests 权限）
+// 通过时与添加成员在同一事务中完成，申请行加锁避免被重复处理
+func DecideJoinRequest(h *hub.Hub, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		requestID, err := strconv.Atoi(vars["requestId"])
+		if err != nil {
+			http.Error(w, "Invalid request ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.ApproveJoinRequests); !ok {
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var req models.JoinRequest
+		err = scanJoinRequest(tx.QueryRow(`
+			SELECT `+joinRequestColumns+`
+			FROM room_join_requests jr
+			INNER JOIN users u ON jr.user_id = u.id
+			WHERE jr.id = $1 AND jr.room_id = $2 AND jr.status = $3
+			FOR UPDATE OF jr
+		`, requestID, roomID, models.JoinRequestPending), &req)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Join request not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying join request: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		req.Status = models.JoinRequestDenied
+		if approve {
+			if err := checkRoomWritable(roomID); err != nil {
+				writeMessageError(w, err)
+				return
+			}
+
+			// 与邀请成员使用相同的成员写入逻辑
+			if _, err = addRoomMember(tx, roomID, req.UserID, permissions.RoleMember); err != nil {
+				log.Printf("Error adding member: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			req.Status = models.JoinRequestApproved
+
+			if err = emitMemberEvent(h, tx, roomID, models.EventMemberJoined, req.UserID, req.Username, userID, "approved"); err != nil {
+				log.Printf("Error emitting member event: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		var decidedAt time.Time
+		if err = tx.QueryRow(
+			"UPDATE room_join_requests SET status = $1, decided_by = $2, decided_at = CURRENT_TIMESTAMP WHERE id = $3 RETURNING decided_at",
+			req.Status, userID, req.ID,
+		).Scan(&decidedAt); err != nil {
+			log.Printf("Error updating join request: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		req.DecidedBy = &userID
+		req.DecidedAt = &decidedAt
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 其他审批人据此从待审批列表中移除该申请
+		notifyApprovers(h, "join_request_decided", &req)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	}
+}
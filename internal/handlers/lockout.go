@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"database/sql"
+	"go-chat/internal/database"
+	"go-chat/internal/services/throttle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxUsernameLength 用户名的最大长度，与 users.username 一致
+const maxUsernameLength = 50
+
+// LoginThrottle 登录限流：同一用户名连续失败后指数退避并在多次失败后锁定，同一 IP 连续失败后指数退避
+// 不存在的用户名和存在的用户名按同样的规则计数，响应中不会暴露用户名是否存在
+type LoginThrottle struct {
+	Users *throttle.Limiter
+	IPs   *throttle.Limiter
+}
+
+// dummyPasswordHash 用户不存在或没有密码时用来比较的哈希，使这些请求与密码错误的请求耗时相同
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("go-chat dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to generate dummy password hash: %v", err)
+	}
+	return hash
+})
+
+// throttleKey 返回用户名对应的限流 key：不区分大小写，并截断到用户名的最大长度
+func throttleKey(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	for utf8.RuneCountInString(username) > maxUsernameLength {
+		_, size := utf8.DecodeLastRuneInString(username)
+		username = username[:len(username)-size]
+	}
+	return username
+}
+
+// loginAttempt 一次登录尝试（输入密码或两步验证码）在用户名和 IP 上计入的次数
+type loginAttempt struct {
+	key  string // 用户名对应的限流 key
+	ip   string
+	user throttle.Attempt
+	addr throttle.Attempt
+}
+
+// begin 开始一次登录尝试，同时检查并计入用户名和 IP 的次数
+// wait 大于 0 表示用户名或 IP 还需要等待（取两者中较长的一个），这次尝试没有计数
+func (t *LoginThrottle) begin(username, ip string) (a *loginAttempt, wait time.Duration, err error) {
+	a = &loginAttempt{key: throttleKey(username), ip: ip}
+	if a.user, err = t.Users.Attempt(a.key); err != nil {
+		return nil, 0, err
+	}
+	if a.addr, err = t.IPs.Attempt(ip); err != nil {
+		t.refund(t.Users, a.user)
+		return nil, 0, err
+	}
+
+	if wait = max(a.user.Wait, a.addr.Wait); wait > 0 {
+		// 只有一方需要等待时撤销另一方的计数
+		t.refund(t.Users, a.user)
+		t.refund(t.IPs, a.addr)
+		return nil, wait, nil
+	}
+	return a, 0, nil
+}
+
+// fail 尝试失败：保留计数并写入审计记录，userID 为 0 表示用户名不存在
+// 返回 true 表示这次失败使用户名进入锁定状态
+func (t *LoginThrottle) fail(a *loginAttempt, userID int) (locked bool) {
+	recordLoginEvent(userID, a.key, a.ip, "failure", 0)
+	if !t.Users.Locked(a.user) {
+		return false
+	}
+	log.Printf("Login for %q locked after repeated failures (last attempt from %s)", a.key, a.ip)
+	recordLoginEvent(userID, a.key, a.ip, "lockout", 0)
+	return true
+}
+
+// pass 密码正确但还需要两步验证：撤销这次计数，之前的失败记录保留到第二步完成
+func (t *LoginThrottle) pass(a *loginAttempt) {
+	t.refund(t.Users, a.user)
+	t.refund(t.IPs, a.addr)
+}
+
+// succeed 登录完成后清除用户名的失败记录；IP 只撤销这次计数，避免攻击者用自己的账号登录来重置计数
+func (t *LoginThrottle) succeed(a *loginAttempt) {
+	if err := t.Users.Reset(a.key); err != nil {
+		log.Printf("Error resetting login failures: %v", err)
+	}
+	t.refund(t.IPs, a.addr)
+}
+
+// refund 撤销一次计数，出错时只记录日志
+func (t *LoginThrottle) refund(l *throttle.Limiter, a throttle.Attempt) {
+	if err := l.Refund(a); err != nil {
+		log.Printf("Error refunding login attempt for %q: %v", a.Key, err)
+	}
+}
+
+// recordLoginEvent 写入登录审计记录，userID 和 actorID 为 0 时记录为空
+func recordLoginEvent(userID int, username, ip, event string, actorID int) {
+	_, err := database.DB.Exec(
+		"INSERT INTO login_audit (user_id, username, ip, event, actor_id) VALUES ($1, $2, $3, $4, $5)",
+		sql.NullInt64{Int64: int64(userID), Valid: userID != 0}, username, ip, event,
+		sql.NullInt64{Int64: int64(actorID), Valid: actorID != 0},
+	)
+	if err != nil {
+		log.Printf("Error recording login event: %v", err)
+	}
+}
+
+// tooManyAttempts 返回 429，Retry-After 为需要等待的秒数
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+}
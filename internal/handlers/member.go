@@ -6,6 +6,8 @@ import (
 	"go-chat/internal/database"
 	"go-chat/internal/middleware"
 	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,254 +15,424 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// InviteMember 邀请成员加入房间
-func InviteMember(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// InviteMember 邀请成员加入房间，成功后发出 member.joined 事件
+func InviteMember(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		currentUserID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 检查当前用户在房间中的角色
+		role, err := getMemberRole(database.DB, roomID, currentUserID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "You are not a member of this room", http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Printf("Error checking user role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 一对一私聊的成员固定为两人
+		kind, err := getRoomKind(roomID)
+		if err != nil {
+			log.Printf("Error querying room kind: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if kind == models.RoomKindDM {
+			http.Error(w, "Cannot invite members to a direct message", http.StatusForbidden)
+			return
+		}
+
+		if !permissions.Can(role, permissions.Invite) {
+			http.Error(w, permissions.Denied(permissions.Invite), http.StatusForbidden)
+			return
+		}
+
+		var req models.InviteMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+
+		// 查找要邀请的用户
+		var invitedUserID int
+		err = database.DB.QueryRow(
+			"SELECT id FROM users WHERE username = $1",
+			req.Username,
+		).Scan(&invitedUserID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error finding user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 添加用户到房间，事件与成员记录在同一事务中写入
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		added, err := addRoomMember(tx, roomID, invitedUserID, permissions.RoleMember)
+		if err != nil {
+			log.Printf("Error adding member: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !added {
+			http.Error(w, "User is already a member of this room", http.StatusConflict)
+			return
+		}
+
+		if err = emitMemberEvent(h, tx, roomID, models.EventMemberJoined, invitedUserID, req.Username, currentUserID, "invited"); err != nil {
+			log.Printf("Error emitting member event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Member invited successfully",
+		})
 	}
-
-	vars := mux.Vars(r)
-	roomID, err := strconv.Atoi(vars["id"])
-	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
-		return
-	}
-
-	currentUserID, ok := middleware.GetUserID(r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// 检查当前用户是否是房间的创建者
-	var role string
-	err = database.DB.QueryRow(
-		"SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, currentUserID,
-	).Scan(&role)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "You are not a member of this room", http.StatusForbidden)
-		return
-	} else if err != nil {
-		log.Printf("Error checking user role: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if role != "creator" {
-		http.Error(w, "Only the room creator can invite members", http.StatusForbidden)
-		return
-	}
-
-	var req models.InviteMemberRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
-		return
-	}
-
-	// 查找要邀请的用户
-	var invitedUserID int
-	err = database.DB.QueryRow(
-		"SELECT id FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&invitedUserID)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	} else if err != nil {
-		log.Printf("Error finding user: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	// 检查用户是否已经是房间成员
-	var exists bool
-	err = database.DB.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
-		roomID, invitedUserID,
-	).Scan(&exists)
-
-	if err != nil {
-		log.Printf("Error checking membership: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if exists {
-		http.Error(w, "User is already a member of this room", http.StatusConflict)
-		return
-	}
-
-	// 添加用户到房间
-	_, err = database.DB.Exec(
-		"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)",
-		roomID, invitedUserID, "member",
-	)
-
-	if err != nil {
-		log.Printf("Error adding member: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Member invited successfully",
-	})
 }
 
-// RemoveMember 从房间移除成员
-func RemoveMember(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	vars := mux.Vars(r)
-	roomID, err := strconv.Atoi(vars["id"])
-	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
-		return
-	}
-
-	memberID, err := strconv.Atoi(vars["memberId"])
-	if err != nil {
-		http.Error(w, "Invalid member ID", http.StatusBadRequest)
-		return
+// RemoveMember 从房间移除成员，成功后发出 member.removed 事件
+func RemoveMember(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		memberID, err := strconv.Atoi(vars["memberId"])
+		if err != nil {
+			http.Error(w, "Invalid member ID", http.StatusBadRequest)
+			return
+		}
+
+		currentUserID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 检查当前用户在房间中的角色
+		role, err := getMemberRole(database.DB, roomID, currentUserID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "You are not a member of this room", http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Printf("Error checking user role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 一对一私聊的成员固定为两人
+		kind, err := getRoomKind(roomID)
+		if err != nil {
+			log.Printf("Error querying room kind: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if kind == models.RoomKindDM {
+			http.Error(w, "Cannot remove members from a direct message", http.StatusForbidden)
+			return
+		}
+
+		if !permissions.Can(role, permissions.Kick) {
+			http.Error(w, permissions.Denied(permissions.Kick), http.StatusForbidden)
+			return
+		}
+
+		// 只能移除角色等级低于自己的成员
+		memberRole, err := getMemberRole(database.DB, roomID, memberID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found in this room", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error checking member role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !permissions.CanActOn(role, memberRole, permissions.Kick) {
+			http.Error(w, "Cannot remove a member with an equal or higher role", http.StatusForbidden)
+			return
+		}
+
+		// 移除成员，同时取出用户名用于事件
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var memberName string
+		err = tx.QueryRow(`
+			DELETE FROM room_members rm USING users u
+			WHERE rm.room_id = $1 AND rm.user_id = $2 AND u.id = rm.user_id
+			RETURNING u.username
+		`, roomID, memberID).Scan(&memberName)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found in this room", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error removing member: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = emitMemberEvent(h, tx, roomID, models.EventMemberRemoved, memberID, memberName, currentUserID, "removed"); err != nil {
+			log.Printf("Error emitting member event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 断开被移除成员的在线连接
+		h.DisconnectUser(roomID, memberID, models.WebSocketMessage{
+			Type:   "removed",
+			RoomID: roomID,
+			UserID: memberID,
+		}, hub.CloseRemoved, "removed")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Member removed successfully",
+		})
 	}
-
-	currentUserID, ok := middleware.GetUserID(r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// 检查当前用户是否是房间的创建者
-	var role string
-	err = database.DB.QueryRow(
-		"SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, currentUserID,
-	).Scan(&role)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "You are not a member of this room", http.StatusForbidden)
-		return
-	} else if err != nil {
-		log.Printf("Error checking user role: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if role != "creator" {
-		http.Error(w, "Only the room creator can remove members", http.StatusForbidden)
-		return
-	}
-
-	// 不能移除创建者
-	var memberRole string
-	err = database.DB.QueryRow(
-		"SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, memberID,
-	).Scan(&memberRole)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "Member not found in this room", http.StatusNotFound)
-		return
-	} else if err != nil {
-		log.Printf("Error checking member role: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if memberRole == "creator" {
-		http.Error(w, "Cannot remove the room creator", http.StatusForbidden)
-		return
-	}
-
-	// 移除成员
-	_, err = database.DB.Exec(
-		"DELETE FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, memberID,
-	)
-
-	if err != nil {
-		log.Printf("Error removing member: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Member removed successfully",
-	})
 }
 
-// LeaveRoom 离开房间
-func LeaveRoom(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	vars := mux.Vars(r)
-	roomID, err := strconv.Atoi(vars["id"])
-	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
-		return
-	}
-
-	currentUserID, ok := middleware.GetUserID(r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// 检查用户的角色
-	var role string
-	err = database.DB.QueryRow(
-		"SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, currentUserID,
-	).Scan(&role)
-
-	if err == sql.ErrNoRows {
-		http.Error(w, "You are not a member of this room", http.StatusNotFound)
-		return
-	} else if err != nil {
-		log.Printf("Error checking user role: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if role == "creator" {
-		http.Error(w, "Room creator cannot leave the room. Please delete the room instead.", http.StatusForbidden)
-		return
+// LeaveRoom 离开房间，成功后发出 member.removed 事件
+func LeaveRoom(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		currentUserID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 检查用户的角色
+		role, err := getMemberRole(database.DB, roomID, currentUserID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "You are not a member of this room", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error checking user role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 一对一私聊的成员固定为两人
+		kind, err := getRoomKind(roomID)
+		if err != nil {
+			log.Printf("Error querying room kind: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if kind == models.RoomKindDM {
+			http.Error(w, "Cannot leave a direct message", http.StatusForbidden)
+			return
+		}
+
+		if role == permissions.RoleCreator {
+			http.Error(w, "Room creator cannot leave the room. Please transfer ownership or delete the room instead.", http.StatusForbidden)
+			return
+		}
+
+		// 离开房间
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(
+			"DELETE FROM room_members WHERE room_id = $1 AND user_id = $2",
+			roomID, currentUserID,
+		)
+
+		if err != nil {
+			log.Printf("Error leaving room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		username, _ := middleware.GetUsername(r)
+		if err = emitMemberEvent(h, tx, roomID, models.EventMemberRemoved, currentUserID, username, 0, "left"); err != nil {
+			log.Printf("Error emitting member event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 关闭自己在该房间的其他连接（例如其他标签页）
+		h.DisconnectUser(roomID, currentUserID, models.WebSocketMessage{
+			Type:   "removed",
+			RoomID: roomID,
+			UserID: currentUserID,
+		}, hub.CloseRemoved, "left")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Left room successfully",
+		})
 	}
+}
 
-	// 离开房间
-	_, err = database.DB.Exec(
-		"DELETE FROM room_members WHERE room_id = $1 AND user_id = $2",
-		roomID, currentUserID,
-	)
-
-	if err != nil {
-		log.Printf("Error leaving room: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+// ChangeMemberRole 修改成员角色（需要 ManageRoles 权限，即房间创建者）
+// 可设置为 admin、moderator 或 member；创建者只能通过转让房间变更
+func ChangeMemberRole(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		memberID, err := strconv.Atoi(vars["memberId"])
+		if err != nil {
+			http.Error(w, "Invalid member ID", http.StatusBadRequest)
+			return
+		}
+
+		currentUserID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, currentUserID, permissions.ManageRoles); !ok {
+			return
+		}
+
+		var req models.ChangeRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		newRole := permissions.Role(req.Role)
+		if !permissions.Assignable(newRole) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+
+		memberRole, err := getMemberRole(database.DB, roomID, memberID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found in this room", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error checking member role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if memberRole == permissions.RoleCreator {
+			http.Error(w, "Cannot change the room creator's role", http.StatusForbidden)
+			return
+		}
+
+		_, err = database.DB.Exec(
+			"UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3",
+			newRole, roomID, memberID,
+		)
+
+		if err != nil {
+			log.Printf("Error changing member role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 通知在线的客户端刷新成员权限
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:   "role_changed",
+			RoomID: roomID,
+			UserID: memberID,
+			Role:   string(newRole),
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Member role changed successfully",
+		})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Left room successfully",
-	})
 }
@@ -0,0 +1,703 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// 消息操作的错误，错误信息可以直接返回给客户端
+var (
+	errMessageNotFound = errors.New("Message not found")
+	errMessageEmpty    = errors.New("Message content is required")
+	errRoomArchived    = errors.New("This room is archived")
+	errSaveFailed      = errors.New("Failed to save message")
+	errPermission      = errors.New("Permission denied")
+	errInternal        = errors.New("Internal server error")
+	errIntegration     = errors.New("Messages posted by integrations cannot be edited")
+)
+
+// messageColumns 查询消息时使用的列（messages m INNER JOIN users u），需与 scanMessage 保持一致
+// 集成发送的消息显示集成指定的名称
+const messageColumns = `m.id, m.room_id, m.user_id, COALESCE(m.sender_name, u.username), m.content, m.created_at, m.edited_at,
+	COALESCE(m.parent_id, 0), m.reply_count, m.last_reply_at, m.pinned_at, COALESCE(m.pinned_by, 0),
+	m.sender_name IS NOT NULL, COALESCE(m.webhook_id, 0), m.embeds`
+
+const (
+	// defaultMessagePageSize 默认每页消息数
+	defaultMessagePageSize = 50
+
+	// maxMessagePageSize 每页消息数上限
+	maxMessagePageSize = 100
+)
+
+// messagePage 消息分页参数
+// Before/After 为消息 ID 游标（0 表示不限制），消息 ID 单调递增，
+// 因此按 ID 排序即使 created_at 相同也是稳定的
+type messagePage struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage 按 messageColumns 的顺序扫描一条消息
+func scanMessage(row rowScanner, msg *models.Message) error {
+	var editedAt, lastReplyAt, pinnedAt sql.NullTime
+	var embeds []byte
+	if err := row.Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Username, &msg.Content, &msg.CreatedAt, &editedAt,
+		&msg.ParentID, &msg.ReplyCount, &lastReplyAt, &pinnedAt, &msg.PinnedBy,
+		&msg.Integration, &msg.WebhookID, &embeds); err != nil {
+		return err
+	}
+	if len(embeds) > 0 {
+		if err := json.Unmarshal(embeds, &msg.Embeds); err != nil {
+			return err
+		}
+	}
+	if pinnedAt.Valid {
+		msg.PinnedAt = &pinnedAt.Time
+	}
+	if editedAt.Valid {
+		msg.EditedAt = &editedAt.Time
+	}
+	if lastReplyAt.Valid {
+		msg.LastReplyAt = &lastReplyAt.Time
+	}
+	return nil
+}
+
+// messageStore 基于数据库的 hub.MessageStore 实现
+type messageStore struct {
+	hub *hub.Hub
+}
+
+// SaveMessage 保存新消息
+func (s messageStore) SaveMessage(msg *models.Message) error {
+	err := saveMessageToDB(s.hub, msg)
+	if err == nil || err == errMessageNotFound || err == errAttachmentInvalid {
+		return err
+	}
+	log.Printf("Error saving message: %v", err)
+	return errSaveFailed
+}
+
+// GetMessage 获取一条未删除的消息
+func (messageStore) GetMessage(roomID, messageID int) (*models.Message, error) {
+	return getMessage(roomID, messageID)
+}
+
+// EditMessage 修改消息内容
+func (messageStore) EditMessage(roomID, messageID, userID int, content string) (*models.Message, error) {
+	return editMessage(roomID, messageID, userID, content)
+}
+
+// DeleteMessage 删除消息
+func (messageStore) DeleteMessage(roomID, messageID, userID int) (*models.Message, error) {
+	return deleteMessage(roomID, messageID, userID)
+}
+
+// IsMember 判断用户是否是房间成员
+func (messageStore) IsMember(roomID, userID int) (bool, error) {
+	var member bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+		roomID, userID,
+	).Scan(&member)
+	return member, err
+}
+
+// IsRoomArchived 判断房间是否已归档
+func (messageStore) IsRoomArchived(roomID int) (bool, error) {
+	return isRoomArchived(roomID)
+}
+
+// isRoomArchived 判断房间是否已归档，房间不存在时视为未归档
+func isRoomArchived(roomID int) (bool, error) {
+	var archived bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM rooms WHERE id = $1 AND archived_at IS NOT NULL)",
+		roomID,
+	).Scan(&archived)
+	return archived, err
+}
+
+// checkRoomWritable 检查房间是否可写，归档的房间返回 errRoomArchived
+func checkRoomWritable(roomID int) error {
+	archived, err := isRoomArchived(roomID)
+	if err != nil {
+		log.Printf("Error checking room archive state: %v", err)
+		return errInternal
+	}
+	if archived {
+		return errRoomArchived
+	}
+	return nil
+}
+
+// getMessage 获取一条未删除的消息
+func getMessage(roomID, messageID int) (*models.Message, error) {
+	var msg models.Message
+	err := scanMessage(database.DB.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1 AND m.room_id = $2 AND m.deleted_at IS NULL
+	`, messageID, roomID), &msg)
+
+	if err == sql.ErrNoRows {
+		return nil, errMessageNotFound
+	} else if err != nil {
+		log.Printf("Error querying message: %v", err)
+		return nil, errInternal
+	}
+	return &msg, nil
+}
+
+// refreshThreadStats 重新统计父消息的回复数和最后回复时间
+func refreshThreadStats(tx *sql.Tx, parentID int) error {
+	_, err := tx.Exec(`
+		UPDATE messages SET
+			reply_count = (SELECT COUNT(*) FROM messages WHERE parent_id = $1 AND deleted_at IS NULL),
+			last_reply_at = (SELECT MAX(created_at) FROM messages WHERE parent_id = $1 AND deleted_at IS NULL)
+		WHERE id = $1
+	`, parentID)
+	return err
+}
+
+// lockedMessage 被锁定以便修改的消息
+type lockedMessage struct {
+	Content     string
+	ParentID    int
+	Integration bool
+}
+
+// lockMessageForUpdate 锁定一条未删除的消息，并检查 userID 是否有权修改它
+// 消息作者可以修改自己的消息；ownOnly 为 false 时（删除），拥有 DeleteOthersMessages 权限且
+// 角色等级高于作者的成员也可以。编辑只允许作者本人，消息仍显示作者的名字，不能让别人改写其内容
+// 集成消息的作者是 webhook 的创建者，但按普通成员的消息处理，协管员也可以删除
+func lockMessageForUpdate(tx *sql.Tx, roomID, messageID, userID int, ownOnly bool) (*lockedMessage, error) {
+	var authorID int
+	var authorRole permissions.Role
+	var locked lockedMessage
+	err := tx.QueryRow(`
+		SELECT m.user_id, m.content, COALESCE(m.parent_id, 0), m.sender_name IS NOT NULL, COALESCE(rm.role, '')
+		FROM messages m
+		LEFT JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = m.user_id
+		WHERE m.id = $1 AND m.room_id = $2 AND m.deleted_at IS NULL
+		FOR UPDATE OF m
+	`, messageID, roomID).Scan(&authorID, &locked.Content, &locked.ParentID, &locked.Integration, &authorRole)
+
+	if err == sql.ErrNoRows {
+		return nil, errMessageNotFound
+	} else if err != nil {
+		log.Printf("Error querying message: %v", err)
+		return nil, errInternal
+	}
+
+	if locked.Integration {
+		authorRole = permissions.RoleMember
+	}
+
+	if authorID == userID {
+		return &locked, nil
+	}
+	if ownOnly {
+		return nil, errPermission
+	}
+
+	role, err := getMemberRole(tx, roomID, userID)
+	if err == sql.ErrNoRows {
+		return nil, errPermission
+	} else if err != nil {
+		log.Printf("Error checking user role: %v", err)
+		return nil, errInternal
+	}
+
+	if !permissions.CanActOn(role, authorRole, permissions.DeleteOthersMessages) {
+		return nil, errPermission
+	}
+	return &locked, nil
+}
+
+// editMessage 修改消息内容并记录编辑历史
+func editMessage(roomID, messageID, userID int, content string) (*models.Message, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errMessageEmpty
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, errInternal
+	}
+	defer tx.Rollback()
+
+	locked, err := lockMessageForUpdate(tx, roomID, messageID, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	if locked.Integration {
+		return nil, errIntegration
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO message_edits (message_id, editor_id, action, old_content, new_content) VALUES ($1, $2, $3, $4, $5)",
+		messageID, userID, "edit", locked.Content, content,
+	); err != nil {
+		log.Printf("Error recording message edit: %v", err)
+		return nil, errInternal
+	}
+
+	if _, err = tx.Exec(
+		"UPDATE messages SET content = $1, edited_at = CURRENT_TIMESTAMP WHERE id = $2",
+		content, messageID,
+	); err != nil {
+		log.Printf("Error editing message: %v", err)
+		return nil, errInternal
+	}
+
+	var msg models.Message
+	err = scanMessage(tx.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1
+	`, messageID), &msg)
+	if err != nil {
+		log.Printf("Error loading edited message: %v", err)
+		return nil, errInternal
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, errInternal
+	}
+
+	return &msg, nil
+}
+
+// deleteMessage 软删除消息，原内容保留在编辑历史中
+// 返回被删除消息的 ID 和所属线程，用于通知客户端
+func deleteMessage(roomID, messageID, userID int) (*models.Message, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, errInternal
+	}
+	defer tx.Rollback()
+
+	locked, err := lockMessageForUpdate(tx, roomID, messageID, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO message_edits (message_id, editor_id, action, old_content) VALUES ($1, $2, $3, $4)",
+		messageID, userID, "delete", locked.Content,
+	); err != nil {
+		log.Printf("Error recording message deletion: %v", err)
+		return nil, errInternal
+	}
+
+	if _, err = tx.Exec(
+		"UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $1 WHERE id = $2",
+		userID, messageID,
+	); err != nil {
+		log.Printf("Error deleting message: %v", err)
+		return nil, errInternal
+	}
+
+	// 删除回复时更新父消息的回复统计
+	if locked.ParentID != 0 {
+		if err = refreshThreadStats(tx, locked.ParentID); err != nil {
+			log.Printf("Error refreshing thread stats: %v", err)
+			return nil, errInternal
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, errInternal
+	}
+
+	return &models.Message{ID: messageID, RoomID: roomID, ParentID: locked.ParentID}, nil
+}
+
+// writeMessageError 将消息操作的错误转换为 HTTP 响应
+func writeMessageError(w http.ResponseWriter, err error) {
+	switch err {
+	case errMessageNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errMessageEmpty:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errPermission, errRoomArchived, errNotMember, errIntegration:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// parseMessageVars 解析路由中的房间 ID 和消息 ID
+func parseMessageVars(r *http.Request) (roomID, messageID int, err error) {
+	vars := mux.Vars(r)
+	if roomID, err = strconv.Atoi(vars["id"]); err != nil {
+		return 0, 0, err
+	}
+	if messageID, err = strconv.Atoi(vars["messageId"]); err != nil {
+		return 0, 0, err
+	}
+	return roomID, messageID, nil
+}
+
+// EditMessage 编辑消息
+func EditMessage(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, messageID, err := parseMessageVars(r)
+		if err != nil {
+			http.Error(w, "Invalid room or message ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req models.EditMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		msg, err := editMessage(roomID, messageID, userID, req.Content)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		// 通知房间内所有客户端更新消息
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:    "message_edit",
+			RoomID:  roomID,
+			Message: msg,
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msg)
+	}
+}
+
+// DeleteMessage 删除消息
+func DeleteMessage(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, messageID, err := parseMessageVars(r)
+		if err != nil {
+			http.Error(w, "Invalid room or message ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		deleted, err := deleteMessage(roomID, messageID, userID)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		// 通知房间内所有客户端移除消息，删除的是回复时附带更新后的父消息
+		deleteMsg := models.WebSocketMessage{
+			Type:      "message_delete",
+			RoomID:    roomID,
+			MessageID: messageID,
+		}
+		if deleted.ParentID != 0 {
+			deleteMsg.Parent, _ = getMessage(roomID, deleted.ParentID)
+		}
+		h.BroadcastToRoom(roomID, deleteMsg, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Message deleted successfully",
+		})
+	}
+}
+
+// GetMessageEdits 获取消息的编辑历史（需要管理他人消息的权限）
+func GetMessageEdits(w http.ResponseWriter, r *http.Request) {
+	roomID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, "Invalid room or message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.DeleteOthersMessages); !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.message_id, COALESCE(e.editor_id, 0), COALESCE(u.username, ''),
+		       e.action, e.old_content, COALESCE(e.new_content, ''), e.created_at
+		FROM message_edits e
+		INNER JOIN messages m ON e.message_id = m.id
+		LEFT JOIN users u ON e.editor_id = u.id
+		WHERE e.message_id = $1 AND m.room_id = $2
+		ORDER BY e.id ASC
+	`, messageID, roomID)
+
+	if err != nil {
+		log.Printf("Error querying message edits: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.EditorID, &edit.EditorName,
+			&edit.Action, &edit.OldContent, &edit.NewContent, &edit.CreatedAt); err != nil {
+			log.Printf("Error scanning message edit: %v", err)
+			continue
+		}
+		edits = append(edits, edit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edits)
+}
+
+// GetThread 获取消息线程（父消息及其所有回复）
+func GetThread(w http.ResponseWriter, r *http.Request) {
+	roomID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, "Invalid room or message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r)
+
+	// 检查用户是否是房间成员
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+		roomID, userID,
+	).Scan(&exists)
+
+	if err != nil || !exists {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	parent, err := getMessage(roomID, messageID)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE m.parent_id = $1 AND m.deleted_at IS NULL
+		ORDER BY m.id ASC
+	`, parent.ID)
+
+	if err != nil {
+		log.Printf("Error querying thread replies: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	thread := models.Thread{Parent: *parent, Replies: []models.Message{}}
+	for rows.Next() {
+		var msg models.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			log.Printf("Error scanning message: %v", err)
+			continue
+		}
+		thread.Replies = append(thread.Replies, msg)
+	}
+
+	if err := attachMessageDetails(thread.Replies); err != nil {
+		log.Printf("Error querying message details: %v", err)
+	}
+	parents := []models.Message{thread.Parent}
+	if err := attachMessageDetails(parents); err == nil {
+		thread.Parent = parents[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thread)
+}
+
+// queryRoomMessages 分页查询房间主消息流（不含线程回复和已删除消息）
+// 返回的消息按 ID 升序排列，hasMore 表示游标方向上是否还有更多消息
+func queryRoomMessages(roomID int, page messagePage) ([]models.Message, bool, error) {
+	where := "m.room_id = $1 AND m.deleted_at IS NULL AND m.parent_id IS NULL"
+	args := []interface{}{roomID}
+	order := "DESC"
+
+	if page.After > 0 {
+		// 向后翻页：从游标处往新的方向取
+		args = append(args, page.After)
+		where += " AND m.id > $2"
+		order = "ASC"
+	} else if page.Before > 0 {
+		args = append(args, page.Before)
+		where += " AND m.id < $2"
+	}
+
+	// 多取一条用于判断是否还有更多
+	args = append(args, page.Limit+1)
+	rows, err := database.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE `+where+`
+		ORDER BY m.id `+order+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var msg models.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+
+	// 倒序查询的结果反转为最旧的在前
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	if err := attachMessageDetails(messages); err != nil {
+		return nil, false, err
+	}
+	return messages, hasMore, nil
+}
+
+// parseCursor 解析可选的正整数查询参数，缺省时返回 0
+func parseCursor(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+// GetRoomMessages 分页获取房间历史消息
+// 查询参数：before / after 为消息 ID 游标（二选一），limit 为每页条数
+func GetRoomMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r)
+
+	// 检查用户是否是房间成员
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+		roomID, userID,
+	).Scan(&exists)
+
+	if err != nil || !exists {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	page := messagePage{Limit: defaultMessagePageSize}
+	if page.Before, err = parseCursor(r, "before"); err != nil {
+		http.Error(w, "Invalid before cursor", http.StatusBadRequest)
+		return
+	}
+	if page.After, err = parseCursor(r, "after"); err != nil {
+		http.Error(w, "Invalid after cursor", http.StatusBadRequest)
+		return
+	}
+	if page.Before > 0 && page.After > 0 {
+		http.Error(w, "Only one of before and after may be specified", http.StatusBadRequest)
+		return
+	}
+	if limit, err := parseCursor(r, "limit"); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	} else if limit > 0 {
+		page.Limit = min(limit, maxMessagePageSize)
+	}
+
+	messages, hasMore, err := queryRoomMessages(roomID, page)
+	if err != nil {
+		log.Printf("Error querying messages: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"has_more": hasMore,
+	})
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/services/oidc"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// oidcFlowTimeout 从跳转到 IdP 到回调的最长时间
+	oidcFlowTimeout = 10 * time.Minute
+	// maxProvisionedUsernameLength 自动创建账号时用户名的最大长度，留出去重后缀的位置
+	maxProvisionedUsernameLength = 40
+)
+
+var (
+	// errOIDCEmailUnverified IdP 没有返回已验证的邮箱，无法绑定或创建账号
+	errOIDCEmailUnverified = errors.New("identity provider did not return a verified email")
+	// errOIDCEmailTaken 邮箱已被本地账号使用，但该账号的邮箱尚未验证，不能自动绑定
+	errOIDCEmailTaken = errors.New("email belongs to an unverified local account")
+)
+
+// findProvider 按标识查找身份提供方
+func findProvider(providers []*oidc.Provider, name string) *oidc.Provider {
+	for _, p := range providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// OIDCLogin 跳转到身份提供方登录（授权码 + PKCE），state、nonce 和 verifier 保存在 session 中
+func OIDCLogin(providers []*oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := findProvider(providers, mux.Vars(r)["provider"])
+		if provider == nil {
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		}
+
+		var values [3]string
+		for i := range values {
+			value, err := oidc.RandomString()
+			if err != nil {
+				log.Printf("Error generating OIDC state: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			values[i] = value
+		}
+		state, nonce, verifier := values[0], values[1], values[2]
+
+		authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+		if err != nil {
+			log.Printf("Error starting OIDC login with %s: %v", provider.Name, err)
+			renderLoginPage(w, providers, "Unable to reach "+provider.DisplayName+", please try again later", http.StatusBadGateway)
+			return
+		}
+
+		session, _ := middleware.GetSession(r)
+		session.Values["oidc_provider"] = provider.Name
+		session.Values["oidc_state"] = state
+		session.Values["oidc_nonce"] = nonce
+		session.Values["oidc_verifier"] = verifier
+		session.Values["oidc_started_at"] = time.Now().Unix()
+		if err := session.Save(r, w); err != nil {
+			log.Printf("Error saving session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallback 身份提供方回调：校验 state、用授权码换取并校验 ID Token，然后登录对应的本地账号
+// 本地账号按 provider + sub 查找，找不到时按已验证的邮箱绑定已有账号或自动创建账号
+func OIDCCallback(providers []*oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := findProvider(providers, mux.Vars(r)["provider"])
+		if provider == nil {
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		}
+
+		session, _ := middleware.GetSession(r)
+		name, _ := session.Values["oidc_provider"].(string)
+		state, _ := session.Values["oidc_state"].(string)
+		nonce, _ := session.Values["oidc_nonce"].(string)
+		verifier, _ := session.Values["oidc_verifier"].(string)
+		startedAt, _ := session.Values["oidc_started_at"].(int64)
+
+		// 每次登录流程的 state 只能使用一次
+		for _, key := range []string{"oidc_provider", "oidc_state", "oidc_nonce", "oidc_verifier", "oidc_started_at"} {
+			delete(session.Values, key)
+		}
+		if err := session.Save(r, w); err != nil {
+			log.Printf("Error saving session: %v", err)
+		}
+
+		query := r.URL.Query()
+		if errCode := query.Get("error"); errCode != "" {
+			log.Printf("OIDC login with %s failed: %s %s", provider.Name, errCode, query.Get("error_description"))
+			renderLoginPage(w, providers, provider.DisplayName+" sign-in failed or was cancelled", http.StatusUnauthorized)
+			return
+		}
+
+		if state == "" || name != provider.Name ||
+			subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 ||
+			time.Since(time.Unix(startedAt, 0)) > oidcFlowTimeout {
+			renderLoginPage(w, providers, "Sign-in request expired, please try again", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := provider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+		if err != nil {
+			log.Printf("Error completing OIDC login with %s: %v", provider.Name, err)
+			renderLoginPage(w, providers, provider.DisplayName+" sign-in failed, please try again", http.StatusUnauthorized)
+			return
+		}
+
+		userID, username, twoFactor, err := resolveOIDCUser(provider.Name, claims)
+		switch {
+		case err == errOIDCEmailUnverified:
+			renderLoginPage(w, providers, provider.DisplayName+" did not provide a verified email address", http.StatusForbidden)
+			return
+		case err == errOIDCEmailTaken:
+			renderLoginPage(w, providers, "This email is used by an account with an unverified email. Sign in with your password and verify it first", http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("Error resolving OIDC user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 本地开启了两步验证的账号通过单点登录时仍需输入验证码
+		if err := startSession(w, r, userID, username, twoFactor); err != nil {
+			log.Printf("Error saving session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if twoFactor {
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/rooms", http.StatusSeeOther)
+	}
+}
+
+// resolveOIDCUser 返回身份提供方账号对应的本地用户，必要时绑定或创建
+func resolveOIDCUser(provider string, claims *oidc.Claims) (userID int, username string, twoFactor bool, err error) {
+	// 已绑定的账号
+	err = database.DB.QueryRow(`
+		UPDATE user_identities i SET last_login_at = CURRENT_TIMESTAMP, email = $3
+		FROM users u
+		WHERE i.user_id = u.id AND i.provider = $1 AND i.subject = $2
+		RETURNING u.id, u.username, u.totp_enabled_at IS NOT NULL
+	`, provider, claims.Subject, claims.Email).Scan(&userID, &username, &twoFactor)
+	if err != sql.ErrNoRows {
+		return userID, username, twoFactor, err
+	}
+
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return 0, "", false, errOIDCEmailUnverified
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, "", false, err
+	}
+	defer tx.Rollback()
+
+	// 按邮箱绑定已有账号：只绑定邮箱已验证的账号，
+	// 否则别人可以先用受害者的邮箱注册，等受害者单点登录后接管其聊天记录
+	var emailVerified bool
+	err = tx.QueryRow(`
+		SELECT id, username, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
+		FROM users WHERE LOWER(email) = LOWER($1)
+		FOR UPDATE
+	`, claims.Email).Scan(&userID, &username, &emailVerified, &twoFactor)
+
+	switch {
+	case err == nil && !emailVerified:
+		return 0, "", false, errOIDCEmailTaken
+	case err == sql.ErrNoRows:
+		userID, username, err = provisionOIDCUser(tx, claims)
+		if err != nil {
+			return 0, "", false, err
+		}
+	case err != nil:
+		return 0, "", false, err
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, provider, claims.Subject, claims.Email,
+	); err != nil {
+		return 0, "", false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, "", false, err
+	}
+	return userID, username, twoFactor, nil
+}
+
+// provisionOIDCUser 为身份提供方账号创建本地用户
+// 用户没有密码（只能通过单点登录或重置密码后登录），邮箱视为已验证
+func provisionOIDCUser(tx *sql.Tx, claims *oidc.Claims) (int, string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.TrimSpace(base)
+	for utf8.RuneCountInString(base) > maxProvisionedUsernameLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	// 用户名已被占用时加随机后缀重试
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		var userID int
+		err := tx.QueryRow(`
+			INSERT INTO users (username, email, password_hash, email_verified_at)
+			VALUES ($1, $2, '', CURRENT_TIMESTAMP)
+			ON CONFLICT (username) DO NOTHING
+			RETURNING id
+		`, candidate, claims.Email).Scan(&userID)
+		if err == nil {
+			return userID, candidate, nil
+		} else if err != sql.ErrNoRows {
+			return 0, "", err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return 0, "", err
+		}
+		candidate = fmt.Sprintf("%s-%04d", base, n.Int64())
+	}
+	return 0, "", errors.New("could not find a free username")
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"database/sql"
+	"go-chat/internal/database"
+	"go-chat/internal/permissions"
+	"log"
+	"net/http"
+)
+
+// queryRower 可以执行单行查询的对象（*sql.DB 或 *sql.Tx）
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// execer 可以执行写操作的对象（*sql.DB 或 *sql.Tx）
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addRoomMember 以指定角色添加房间成员，用户已是成员时不做修改并返回 false
+func addRoomMember(e execer, roomID, userID int, role permissions.Role) (bool, error) {
+	result, err := e.Exec(
+		"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (room_id, user_id) DO NOTHING",
+		roomID, userID, role,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// getMemberRole 获取用户在房间中的角色，不是房间成员时返回 sql.ErrNoRows
+func getMemberRole(q queryRower, roomID, userID int) (permissions.Role, error) {
+	var role permissions.Role
+	err := q.QueryRow(
+		"SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2",
+		roomID, userID,
+	).Scan(&role)
+	return role, err
+}
+
+// checkRoomPermission 检查用户在房间中是否拥有某项权限，没有时写入错误响应并返回 false
+func checkRoomPermission(w http.ResponseWriter, roomID, userID int, action permissions.Action) (permissions.Role, bool) {
+	role, err := getMemberRole(database.DB, roomID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return "", false
+	} else if err != nil {
+		log.Printf("Error checking user role: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if !permissions.Can(role, action) {
+		http.Error(w, permissions.Denied(action), http.StatusForbidden)
+		return role, false
+	}
+	return role, true
+}
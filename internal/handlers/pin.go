@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// MemberRole 获取用户在房间中的角色
+func (messageStore) MemberRole(roomID, userID int) (permissions.Role, error) {
+	return getMemberRole(database.DB, roomID, userID)
+}
+
+// PinMessage 置顶或取消置顶消息，调用方负责检查 Pin 权限
+func (messageStore) PinMessage(roomID, messageID, userID int, pinned bool) (*models.Message, error) {
+	return pinMessage(roomID, messageID, userID, pinned)
+}
+
+// pinMessage 置顶或取消置顶一条未删除的消息，返回更新后的消息
+func pinMessage(roomID, messageID, userID int, pinned bool) (*models.Message, error) {
+	var err error
+	if pinned {
+		_, err = database.DB.Exec(
+			"UPDATE messages SET pinned_at = CURRENT_TIMESTAMP, pinned_by = $1 WHERE id = $2 AND room_id = $3 AND deleted_at IS NULL AND pinned_at IS NULL",
+			userID, messageID, roomID,
+		)
+	} else {
+		_, err = database.DB.Exec(
+			"UPDATE messages SET pinned_at = NULL, pinned_by = NULL WHERE id = $1 AND room_id = $2 AND deleted_at IS NULL",
+			messageID, roomID,
+		)
+	}
+	if err != nil {
+		log.Printf("Error updating pinned state: %v", err)
+		return nil, errInternal
+	}
+
+	return getMessage(roomID, messageID)
+}
+
+// PinMessage 置顶（pinned 为 true）或取消置顶消息，需要 Pin 权限
+func PinMessage(h *hub.Hub, pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, messageID, err := parseMessageVars(r)
+		if err != nil {
+			http.Error(w, "Invalid room or message ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.Pin); !ok {
+			return
+		}
+
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		msg, err := pinMessage(roomID, messageID, userID, pinned)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		eventType := "message_pin"
+		if !pinned {
+			eventType = "message_unpin"
+		}
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:    eventType,
+			RoomID:  roomID,
+			Message: msg,
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msg)
+	}
+}
+
+// GetPinnedMessages 获取房间的置顶消息，最近置顶的在前
+func GetPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r)
+
+	// 检查用户是否是房间成员
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+		roomID, userID,
+	).Scan(&exists)
+
+	if err != nil || !exists {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.pinned_at IS NOT NULL AND m.deleted_at IS NULL
+		ORDER BY m.pinned_at DESC
+	`, roomID)
+	if err != nil {
+		log.Printf("Error querying pinned messages: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var msg models.Message
+		if err := scanMessage(rows, &msg); err != nil {
+			log.Printf("Error scanning message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := attachMessageDetails(messages); err != nil {
+		log.Printf("Error querying message details: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// defaultDirectoryPageSize 房间目录默认每页数量
+	defaultDirectoryPageSize = 20
+
+	// maxDirectoryPageSize 房间目录每页数量上限
+	maxDirectoryPageSize = 50
+)
+
+// parseVisibility 校验房间可见性，value 为空时返回 fallback
+func parseVisibility(value, fallback string) (string, bool) {
+	switch value {
+	case "":
+		return fallback, true
+	case models.RoomVisibilityPrivate, models.RoomVisibilityPublic, models.RoomVisibilityRequest:
+		return value, true
+	}
+	return "", false
+}
+
+// likePattern 把用户输入转换为 ILIKE 子串匹配模式，转义其中的通配符
+func likePattern(q string) string {
+	q = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q)
+	return "%" + q + "%"
+}
+
+// ShowPublicRooms 显示公开房间目录页面
+func ShowPublicRooms(w http.ResponseWriter, r *http.Request) {
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Username string
+	}{
+		Username: username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/browse.html"))
+	tmpl.Execute(w, data)
+}
+
+// GetPublicRooms 获取公开房间目录，包括需要申请加入的房间
+// 查询参数：q（按名称和描述搜索）、limit、offset；已归档的房间不会出现在目录中
+func GetPublicRooms(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetUserID(r)
+	query := r.URL.Query()
+
+	limit := defaultDirectoryPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDirectoryPageSize)
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	// q 为空时匹配所有公开房间；多取一条用于判断是否还有下一页
+	q := strings.TrimSpace(query.Get("q"))
+	rows, err := database.DB.Query(`
+		SELECT r.id, r.name, r.description, r.kind, r.visibility, r.creator_id, r.created_at,
+		       (SELECT COUNT(*) FROM room_members rm WHERE rm.room_id = r.id),
+		       EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $1),
+		       EXISTS(SELECT 1 FROM room_join_requests jr WHERE jr.room_id = r.id AND jr.user_id = $1 AND jr.status = '`+models.JoinRequestPending+`')
+		FROM rooms r
+		WHERE r.visibility = ANY($2) AND r.kind = $3 AND r.archived_at IS NULL
+		  AND ($4 = '' OR r.name ILIKE $5 OR r.description ILIKE $5)
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $6 OFFSET $7
+	`, userID, pq.Array([]string{models.RoomVisibilityPublic, models.RoomVisibilityRequest}), models.RoomKindChannel, q, likePattern(q), limit+1, offset)
+
+	if err != nil {
+		log.Printf("Error querying public rooms: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rooms := []models.PublicRoom{}
+	for rows.Next() {
+		var room models.PublicRoom
+		if err := rows.Scan(
+			&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID, &room.CreatedAt,
+			&room.MemberCount, &room.Joined, &room.Requested,
+		); err != nil {
+			log.Printf("Error scanning public room: %v", err)
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+
+	hasMore := len(rooms) > limit
+	if hasMore {
+		rooms = rooms[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rooms":       rooms,
+		"has_more":    hasMore,
+		"next_offset": offset + len(rooms),
+	})
+}
+
+// JoinRoom 自行加入公开房间，已是成员时直接返回成功
+func JoinRoom(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// 锁定房间行，避免与修改可见性、归档并发；私有房间与不存在的房间返回相同的错误
+		// 需要申请的房间不能直接加入
+		var visibility, kind string
+		var archived bool
+		err = tx.QueryRow(
+			"SELECT visibility, kind, archived_at IS NOT NULL FROM rooms WHERE id = $1 FOR SHARE",
+			roomID,
+		).Scan(&visibility, &kind, &archived)
+
+		if err == sql.ErrNoRows || (err == nil && (visibility == models.RoomVisibilityPrivate || kind != models.RoomKindChannel)) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if archived {
+			http.Error(w, "This room is archived", http.StatusForbidden)
+			return
+		}
+
+		if visibility != models.RoomVisibilityPublic {
+			http.Error(w, "This room requires approval to join", http.StatusForbidden)
+			return
+		}
+
+		joined, err := addRoomMember(tx, roomID, userID, permissions.RoleMember)
+		if err != nil {
+			log.Printf("Error joining room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if joined {
+			username, _ := middleware.GetUsername(r)
+			if err = emitMemberEvent(h, tx, roomID, models.EventMemberJoined, userID, username, 0, "public"); err != nil {
+				log.Printf("Error emitting member event: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		message := "Joined room successfully"
+		if !joined {
+			message = "Already a member of this room"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"room_id": roomID,
+			"message": message,
+		})
+	}
+}
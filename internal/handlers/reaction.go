@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat/internal/database"
+	"go-chat/internal/models"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maxEmojiLength 单个表情允许的最大字符数（组合表情由多个码点组成）
+const maxEmojiLength = 16
+
+var (
+	errInvalidEmoji = errors.New("Invalid emoji")
+	errNotMember    = errors.New("You are not a member of this room")
+)
+
+// AddReaction 添加表情回应
+func (messageStore) AddReaction(roomID, messageID, userID int, emoji string) ([]models.Reaction, error) {
+	return setReaction(roomID, messageID, userID, emoji, true)
+}
+
+// RemoveReaction 取消表情回应
+func (messageStore) RemoveReaction(roomID, messageID, userID int, emoji string) ([]models.Reaction, error) {
+	return setReaction(roomID, messageID, userID, emoji, false)
+}
+
+// validEmoji 检查表情是否合法：非空、长度有限且不含空白或控制字符
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 32 || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return false
+	}
+	return !strings.ContainsFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+// setReaction 添加或取消回应，返回消息更新后的全部回应
+// 与 HandleWebSocket 一样，只有房间成员才能回应
+func setReaction(roomID, messageID, userID int, emoji string, add bool) ([]models.Reaction, error) {
+	if !validEmoji(emoji) {
+		return nil, errInvalidEmoji
+	}
+
+	// 检查用户是否是房间成员
+	var isMember bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+		roomID, userID,
+	).Scan(&isMember)
+	if err != nil {
+		log.Printf("Error checking membership: %v", err)
+		return nil, errInternal
+	}
+	if !isMember {
+		return nil, errNotMember
+	}
+
+	// 检查消息是否属于该房间且未被删除
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND room_id = $2 AND deleted_at IS NULL)",
+		messageID, roomID,
+	).Scan(&exists)
+	if err != nil {
+		log.Printf("Error checking message: %v", err)
+		return nil, errInternal
+	}
+	if !exists {
+		return nil, errMessageNotFound
+	}
+
+	if add {
+		_, err = database.DB.Exec(
+			"INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			messageID, userID, emoji,
+		)
+	} else {
+		_, err = database.DB.Exec(
+			"DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3",
+			messageID, userID, emoji,
+		)
+	}
+	if err != nil {
+		log.Printf("Error updating reaction: %v", err)
+		return nil, errInternal
+	}
+
+	reactions, err := loadReactions([]int{messageID})
+	if err != nil {
+		log.Printf("Error loading reactions: %v", err)
+		return nil, errInternal
+	}
+	return reactions[messageID], nil
+}
+
+// loadReactions 批量查询消息的聚合回应，key 为消息 ID
+func loadReactions(messageIDs []int) (map[int][]models.Reaction, error) {
+	reactions := make(map[int][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT message_id, emoji, COUNT(*), array_agg(user_id ORDER BY created_at)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction models.Reaction
+		var userIDs pq.Int64Array
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &userIDs); err != nil {
+			return nil, err
+		}
+		for _, id := range userIDs {
+			reaction.UserIDs = append(reaction.UserIDs, int(id))
+		}
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+	return reactions, rows.Err()
+}
+
+// attachReactions 为一组消息填充聚合回应
+func attachReactions(messages []models.Message) error {
+	ids := make([]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	reactions, err := loadReactions(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return nil
+}
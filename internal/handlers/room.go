@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"go-chat/internal/database"
 	"go-chat/internal/middleware"
 	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"go-chat/internal/services/storage"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
@@ -17,14 +23,17 @@ import (
 func ShowRoomsList(w http.ResponseWriter, r *http.Request) {
 	userID, _ := middleware.GetUserID(r)
 
+	// 已归档的房间默认不显示，?archived=1 时一并显示
+	showArchived := r.URL.Query().Get("archived") == "1"
+
 	// 获取用户加入的所有房间
 	rows, err := database.DB.Query(`
-		SELECT r.id, r.name, r.description, r.creator_id, r.created_at
+		SELECT r.id, `+roomNameSQL("$1")+`, r.description, r.kind, r.visibility, r.creator_id, r.archived_at, r.created_at
 		FROM rooms r
 		INNER JOIN room_members rm ON r.id = rm.room_id
-		WHERE rm.user_id = $1
+		WHERE rm.user_id = $1 AND (r.archived_at IS NULL OR $2)
 		ORDER BY r.created_at DESC
-	`, userID)
+	`, userID, showArchived)
 
 	if err != nil {
 		log.Printf("Error querying rooms: %v", err)
@@ -33,23 +42,32 @@ func ShowRoomsList(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var rooms []models.Room
+	// 聊天室和私聊分开显示
+	var rooms, directMessages []models.Room
 	for rows.Next() {
 		var room models.Room
-		if err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.CreatorID, &room.CreatedAt); err != nil {
+		if err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID, &room.ArchivedAt, &room.CreatedAt); err != nil {
 			log.Printf("Error scanning room: %v", err)
 			continue
 		}
-		rooms = append(rooms, room)
+		if room.Kind == models.RoomKindChannel {
+			rooms = append(rooms, room)
+		} else {
+			directMessages = append(directMessages, room)
+		}
 	}
 
 	username, _ := middleware.GetUsername(r)
 	data := struct {
-		Rooms    []models.Room
-		Username string
+		Rooms          []models.Room
+		DirectMessages []models.Room
+		ShowArchived   bool
+		Username       string
 	}{
-		Rooms:    rooms,
-		Username: username,
+		Rooms:          rooms,
+		DirectMessages: directMessages,
+		ShowArchived:   showArchived,
+		Username:       username,
 	}
 
 	tmpl := template.Must(template.ParseFiles("web/templates/rooms.html"))
@@ -80,6 +98,12 @@ func CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	visibility, ok := parseVisibility(req.Visibility, models.RoomVisibilityPrivate)
+	if !ok {
+		http.Error(w, "Invalid visibility", http.StatusBadRequest)
+		return
+	}
+
 	// 开始事务
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -92,8 +116,8 @@ func CreateRoom(w http.ResponseWriter, r *http.Request) {
 	// 创建房间
 	var roomID int
 	err = tx.QueryRow(
-		"INSERT INTO rooms (name, description, creator_id) VALUES ($1, $2, $3) RETURNING id",
-		req.Name, req.Description, userID,
+		"INSERT INTO rooms (name, description, creator_id, visibility) VALUES ($1, $2, $3, $4) RETURNING id",
+		req.Name, req.Description, userID, visibility,
 	).Scan(&roomID)
 
 	if err != nil {
@@ -105,7 +129,7 @@ func CreateRoom(w http.ResponseWriter, r *http.Request) {
 	// 将创建者添加为房间成员
 	_, err = tx.Exec(
 		"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)",
-		roomID, userID, "creator",
+		roomID, userID, permissions.RoleCreator,
 	)
 
 	if err != nil {
@@ -140,14 +164,9 @@ func ShowRoom(w http.ResponseWriter, r *http.Request) {
 
 	userID, _ := middleware.GetUserID(r)
 
-	// 检查用户是否是房间成员
-	var exists bool
-	err = database.DB.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
-		roomID, userID,
-	).Scan(&exists)
-
-	if err != nil || !exists {
+	// 检查用户是否是房间成员，并获取其角色
+	role, err := getMemberRole(database.DB, roomID, userID)
+	if err != nil {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -155,9 +174,9 @@ func ShowRoom(w http.ResponseWriter, r *http.Request) {
 	// 获取房间信息
 	var room models.Room
 	err = database.DB.QueryRow(
-		"SELECT id, name, description, creator_id FROM rooms WHERE id = $1",
-		roomID,
-	).Scan(&room.ID, &room.Name, &room.Description, &room.CreatorID)
+		"SELECT r.id, "+roomNameSQL("$2")+", r.description, r.kind, r.visibility, r.creator_id, r.archived_at FROM rooms r WHERE r.id = $1",
+		roomID, userID,
+	).Scan(&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID, &room.ArchivedAt)
 
 	if err != nil {
 		log.Printf("Error querying room: %v", err)
@@ -165,49 +184,31 @@ func ShowRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取历史消息
-	rows, err := database.DB.Query(`
-		SELECT m.id, m.room_id, m.user_id, u.username, m.content, m.created_at
-		FROM messages m
-		INNER JOIN users u ON m.user_id = u.id
-		WHERE m.room_id = $1
-		ORDER BY m.created_at DESC
-		LIMIT 50
-	`, roomID)
-
+	// 获取最近的历史消息，更早的消息由前端通过分页 API 加载
+	messages, hasMore, err := queryRoomMessages(roomID, messagePage{Limit: defaultMessagePageSize})
 	if err != nil {
 		log.Printf("Error querying messages: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var messages []models.Message
-	for rows.Next() {
-		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Username, &msg.Content, &msg.CreatedAt); err != nil {
-			log.Printf("Error scanning message: %v", err)
-			continue
-		}
-		messages = append(messages, msg)
-	}
-
-	// 反转消息顺序（最旧的在前）
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
 
 	username, _ := middleware.GetUsername(r)
 	data := struct {
-		Room     models.Room
-		Messages []models.Message
-		UserID   int
-		Username string
+		Room        models.Room
+		Messages    []models.Message
+		HasMore     bool
+		Role        permissions.Role
+		Permissions map[string]bool
+		UserID      int
+		Username    string
 	}{
-		Room:     room,
-		Messages: messages,
-		UserID:   userID,
-		Username: username,
+		Room:        room,
+		Messages:    messages,
+		HasMore:     hasMore,
+		Role:        role,
+		Permissions: permissions.Allowed(role),
+		UserID:      userID,
+		Username:    username,
 	}
 
 	tmpl := template.Must(template.ParseFiles("web/templates/room.html"))
@@ -223,8 +224,15 @@ func GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 只有房间成员可以查看成员列表，私有房间对非成员不可见
+	userID, _ := middleware.GetUserID(r)
+	if _, err := getMemberRole(database.DB, roomID, userID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
 	rows, err := database.DB.Query(`
-		SELECT u.id, u.username, rm.role
+		SELECT u.id, u.username, u.is_bot, rm.role
 		FROM users u
 		INNER JOIN room_members rm ON u.id = rm.user_id
 		WHERE rm.room_id = $1
@@ -240,13 +248,14 @@ func GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 	type Member struct {
 		ID       int    `json:"id"`
 		Username string `json:"username"`
+		IsBot    bool   `json:"is_bot"`
 		Role     string `json:"role"`
 	}
 
 	var members []Member
 	for rows.Next() {
 		var member Member
-		if err := rows.Scan(&member.ID, &member.Username, &member.Role); err != nil {
+		if err := rows.Scan(&member.ID, &member.Username, &member.IsBot, &member.Role); err != nil {
 			log.Printf("Error scanning member: %v", err)
 			continue
 		}
@@ -256,3 +265,458 @@ func GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(members)
 }
+
+// GetRoomPresence 获取房间当前在线的成员列表
+func GetRoomPresence(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r)
+
+		// 检查用户是否是房间成员
+		var exists bool
+		err = database.DB.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)",
+			roomID, userID,
+		).Scan(&exists)
+
+		if err != nil || !exists {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"room_id": roomID,
+			"online":  h.OnlineUsers(roomID),
+		})
+	}
+}
+
+// maxRoomNameLength 房间名称的最大长度（与 rooms.name 列一致）
+const maxRoomNameLength = 100
+
+// UpdateRoomSettings 修改房间名称和描述（需要 EditRoomSettings 权限）
+func UpdateRoomSettings(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.EditRoomSettings); !ok {
+			return
+		}
+
+		var req models.UpdateRoomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Room name is required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(req.Name) > maxRoomNameLength {
+			http.Error(w, "Room name is too long", http.StatusBadRequest)
+			return
+		}
+
+		// 可见性为空时保持不变
+		visibility, ok := parseVisibility(req.Visibility, "")
+		if !ok {
+			http.Error(w, "Invalid visibility", http.StatusBadRequest)
+			return
+		}
+
+		// 私聊的名称由成员决定，不能修改
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var room models.Room
+		err = tx.QueryRow(`
+			UPDATE rooms SET name = $1, description = $2, visibility = COALESCE(NULLIF($5, ''), visibility),
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3 AND kind = $4
+			RETURNING id, name, description, kind, visibility, creator_id, archived_at, created_at, updated_at
+		`, req.Name, req.Description, roomID, models.RoomKindChannel, visibility).Scan(
+			&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID,
+			&room.ArchivedAt, &room.CreatedAt, &room.UpdatedAt,
+		)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Direct messages have no settings", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error updating room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = h.Emit(tx, roomID, models.EventRoomUpdated, room); err != nil {
+			log.Printf("Error emitting room event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:   "room_updated",
+			RoomID: roomID,
+			Room:   &room,
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(room)
+	}
+}
+
+// DeleteRoom 删除房间（仅房间创建者），房间内的连接会收到 room_deleted 通知并被断开
+func DeleteRoom(h *hub.Hub, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageRoom); !ok {
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// 附件记录会随房间级联删除，先记下存储中的文件以便提交后清理
+		rows, err := tx.Query(
+			"SELECT storage_key, thumbnail_key FROM attachments WHERE room_id = $1",
+			roomID,
+		)
+		if err != nil {
+			log.Printf("Error querying attachments: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			var thumbKey sql.NullString
+			if err := rows.Scan(&key, &thumbKey); err != nil {
+				rows.Close()
+				log.Printf("Error scanning attachment: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			keys = append(keys, key)
+			if thumbKey.Valid {
+				keys = append(keys, thumbKey.String)
+			}
+		}
+		rows.Close()
+
+		// 成员、消息、回应、附件等记录通过外键级联删除
+		if _, err = tx.Exec("DELETE FROM rooms WHERE id = $1", roomID); err != nil {
+			log.Printf("Error deleting room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, key := range keys {
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Printf("Error deleting attachment %s: %v", key, err)
+			}
+		}
+
+		h.DisconnectRoom(roomID, models.WebSocketMessage{
+			Type:   "room_deleted",
+			RoomID: roomID,
+		}, hub.CloseRoomDeleted, "room deleted")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Room deleted successfully",
+		})
+	}
+}
+
+// ArchiveRoom 归档或取消归档房间（仅房间创建者）
+// 归档后房间变为只读，并默认不在房间列表中显示
+func ArchiveRoom(h *hub.Hub, archive bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageRoom); !ok {
+			return
+		}
+
+		query := "UPDATE rooms SET archived_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND archived_at IS NULL"
+		eventType := "room_archived"
+		message := "Room archived successfully"
+		if !archive {
+			query = "UPDATE rooms SET archived_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND archived_at IS NOT NULL"
+			eventType = "room_unarchived"
+			message = "Room unarchived successfully"
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var room models.Room
+		err = tx.QueryRow(
+			query+" RETURNING id, name, description, kind, visibility, creator_id, archived_at, created_at, updated_at",
+			roomID,
+		).Scan(
+			&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID,
+			&room.ArchivedAt, &room.CreatedAt, &room.UpdatedAt,
+		)
+
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error updating room archive state: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 状态确实发生变化时才通知在线的客户端和事件订阅
+		if err == nil {
+			if err = h.Emit(tx, roomID, models.EventRoomUpdated, room); err != nil {
+				log.Printf("Error emitting room event: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if err = tx.Commit(); err != nil {
+				log.Printf("Error committing transaction: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			h.BroadcastToRoom(roomID, models.WebSocketMessage{
+				Type:   eventType,
+				RoomID: roomID,
+			}, nil)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": message,
+		})
+	}
+}
+
+// TransferOwnership 将房间转让给另一名成员（仅房间创建者）
+// 在同一事务中交换 room_members 中的 creator 角色并更新 rooms.creator_id，原创建者降为管理员
+// 提交后通知在线的客户端刷新双方的权限，并发出 room.updated 事件
+func TransferOwnership(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req models.TransferOwnershipRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// 锁定房间，防止并发转让
+		var creatorID int
+		err = tx.QueryRow("SELECT creator_id FROM rooms WHERE id = $1 FOR UPDATE", roomID).Scan(&creatorID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying room: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		role, err := getMemberRole(tx, roomID, userID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "You are not a member of this room", http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Printf("Error checking user role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !permissions.Can(role, permissions.ManageRoom) {
+			http.Error(w, permissions.Denied(permissions.ManageRoom), http.StatusForbidden)
+			return
+		}
+
+		// 新的创建者必须是房间成员
+		var newOwnerID int
+		err = tx.QueryRow(`
+			SELECT u.id FROM users u
+			INNER JOIN room_members rm ON u.id = rm.user_id
+			WHERE rm.room_id = $1 AND u.username = $2
+		`, roomID, req.Username).Scan(&newOwnerID)
+
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found in this room", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error finding member: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if newOwnerID == userID {
+			http.Error(w, "You already own this room", http.StatusBadRequest)
+			return
+		}
+
+		if _, err = tx.Exec(
+			"UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3",
+			permissions.RoleAdmin, roomID, userID,
+		); err != nil {
+			log.Printf("Error demoting creator: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err = tx.Exec(
+			"UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3",
+			permissions.RoleCreator, roomID, newOwnerID,
+		); err != nil {
+			log.Printf("Error promoting member: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var room models.Room
+		if err = tx.QueryRow(`
+			UPDATE rooms SET creator_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+			RETURNING id, name, description, kind, visibility, creator_id, archived_at, created_at, updated_at
+		`, newOwnerID, roomID).Scan(
+			&room.ID, &room.Name, &room.Description, &room.Kind, &room.Visibility, &room.CreatorID,
+			&room.ArchivedAt, &room.CreatedAt, &room.UpdatedAt,
+		); err != nil {
+			log.Printf("Error updating room creator: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = h.Emit(tx, roomID, models.EventRoomUpdated, room); err != nil {
+			log.Printf("Error emitting room event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 通知在线的客户端刷新原创建者和新创建者的权限
+		for _, change := range []struct {
+			userID int
+			role   permissions.Role
+		}{{userID, permissions.RoleAdmin}, {newOwnerID, permissions.RoleCreator}} {
+			h.BroadcastToRoom(roomID, models.WebSocketMessage{
+				Type:   "role_changed",
+				RoomID: roomID,
+				UserID: change.userID,
+				Role:   string(change.role),
+			}, nil)
+		}
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:   "room_updated",
+			RoomID: roomID,
+			Room:   &room,
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Ownership transferred successfully",
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSearchPageSize 默认每页搜索结果数
+	defaultSearchPageSize = 20
+
+	// maxSearchPageSize 每页搜索结果数上限
+	maxSearchPageSize = 50
+
+	// headlineOptions ts_headline 的片段选项
+	headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5"
+)
+
+// parseSearchTime 解析日期（2006-01-02）或 RFC3339 时间
+// endOfDay 为 true 时，纯日期会被解析为当天结束，用于区间上限
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// highlightSnippet 转义搜索片段中的 HTML，只保留 ts_headline 生成的 <mark> 标签
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, "&lt;mark&gt;", "<mark>")
+	return strings.ReplaceAll(escaped, "&lt;/mark&gt;", "</mark>")
+}
+
+// SearchMessages 在当前用户所在的房间中全文搜索消息
+// 查询参数：q（支持 "短语"、OR 和 -排除）、room_id、author、from、to、limit、offset
+func SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	// 只搜索用户所在房间中未删除的消息
+	where := []string{
+		"m.search_vector @@ websearch_to_tsquery('simple', $1)",
+		"m.deleted_at IS NULL",
+		"m.room_id IN (SELECT room_id FROM room_members WHERE user_id = $2)",
+	}
+	args := []interface{}{q, userID}
+
+	addFilter := func(condition string, value interface{}) {
+		args = append(args, value)
+		where = append(where, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if roomID := query.Get("room_id"); roomID != "" {
+		id, err := strconv.Atoi(roomID)
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+		addFilter("m.room_id = ?", id)
+	}
+
+	if author := strings.TrimSpace(query.Get("author")); author != "" {
+		addFilter("u.username = ?", author)
+	}
+
+	if from := query.Get("from"); from != "" {
+		t, err := parseSearchTime(from, false)
+		if err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+		addFilter("m.created_at >= ?", t)
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := parseSearchTime(to, true)
+		if err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		addFilter("m.created_at <= ?", t)
+	}
+
+	limit := defaultSearchPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchPageSize)
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	// 多取一条用于判断是否还有下一页
+	args = append(args, limit+1, offset)
+	rows, err := database.DB.Query(`
+		SELECT `+messageColumns+`, `+roomNameSQL("$2")+`,
+		       ts_headline('simple', m.content, websearch_to_tsquery('simple', $1), '`+headlineOptions+`')
+		FROM messages m
+		INNER JOIN users u ON m.user_id = u.id
+		INNER JOIN rooms r ON m.room_id = r.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY ts_rank(m.search_vector, websearch_to_tsquery('simple', $1)) DESC, m.id DESC
+		LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)), args...)
+
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		var snippet string
+		if err := scanSearchResult(rows, &result, &snippet); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			continue
+		}
+		result.Snippet = highlightSnippet(snippet)
+		results = append(results, result)
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":     results,
+		"has_more":    hasMore,
+		"next_offset": offset + len(results),
+	})
+}
+
+// scanSearchResult 扫描一行搜索结果：messageColumns 之后依次为房间名和高亮片段
+func scanSearchResult(row rowScanner, result *models.SearchResult, snippet *string) error {
+	return scanMessage(scanFunc(func(dest ...interface{}) error {
+		return row.Scan(append(dest, &result.RoomName, snippet)...)
+	}), &result.Message)
+}
+
+// scanFunc 将函数适配为 rowScanner
+type scanFunc func(dest ...interface{}) error
+
+// Scan 实现 rowScanner
+func (f scanFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
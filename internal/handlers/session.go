@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/services/hub"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ShowSessions 显示当前用户的登录设备管理页面
+func ShowSessions(w http.ResponseWriter, r *http.Request) {
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Username string
+	}{
+		Username: username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/sessions.html"))
+	tmpl.Execute(w, data)
+}
+
+// GetSessions 获取当前用户所有未过期的登录 session，当前 session 的 current 为 true
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := middleware.SessionStore().ListUser(userID)
+	if err != nil {
+		log.Printf("Error querying sessions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	current, _ := middleware.GetSession(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession 撤销当前用户的一个 session，并断开该 session 建立的 WebSocket 连接
+// 撤销的是当前 session 时同时清除 cookie
+func RevokeSession(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		publicID, err := strconv.ParseInt(vars["sessionId"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionID, err := middleware.SessionStore().RevokePublic(userID, publicID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error revoking session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.DisconnectSession(sessionID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "session revoked")
+
+		current, _ := middleware.GetSession(r)
+		if current.ID == sessionID {
+			current.Options.MaxAge = -1
+			current.Save(r, w)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"current": current.ID == sessionID,
+			"message": "Session revoked",
+		})
+	}
+}
+
+// RevokeOtherSessions 撤销当前用户除当前 session 以外的全部 session（退出其他设备）
+func RevokeOtherSessions(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		current, _ := middleware.GetSession(r)
+		revoked, err := middleware.SessionStore().RevokeOthers(userID, current.ID)
+		if err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.DisconnectOtherSessions(userID, current.ID, models.WebSocketMessage{Type: "logged_out"}, hub.CloseLoggedOut, "session revoked")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"revoked": revoked,
+			"message": "Logged out on other devices",
+		})
+	}
+}
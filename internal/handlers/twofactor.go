@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/services/totp"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// totpIssuer 认证器应用中显示的服务名称
+	totpIssuer = "Go Chat"
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+)
+
+// hashRecoveryCode 返回恢复码的 SHA-256；忽略大小写、空格和连字符
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes 生成一组随机恢复码，格式为 xxxxx-xxxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes 删除用户原有的恢复码并保存新的恢复码
+func replaceRecoveryCodes(e execer, userID int, codes []string) error {
+	if _, err := e.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := e.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashRecoveryCode(code),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySecondFactor 校验验证码或恢复码，通过后验证码所在周期或恢复码即作废
+func verifySecondFactor(userID int, code string) (bool, error) {
+	var secret string
+	err := database.DB.QueryRow(
+		"SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL",
+		userID,
+	).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		// 只接受比上次更新的周期，同一验证码不能使用两次
+		result, err := database.DB.Exec(
+			"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1",
+			step, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		return n == 1, err
+	}
+
+	result, err := database.DB.Exec(
+		"UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ShowSecurityPage 显示账号安全（两步验证）页面
+func ShowSecurityPage(w http.ResponseWriter, r *http.Request) {
+	username, _ := middleware.GetUsername(r)
+	data := struct {
+		Username string
+	}{
+		Username: username,
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/security.html"))
+	tmpl.Execute(w, data)
+}
+
+// ShowLogin2FAPage 显示登录第二步（输入验证码）页面
+func ShowLogin2FAPage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := middleware.GetPendingMFAUserID(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("web/templates/login_2fa.html"))
+	tmpl.Execute(w, nil)
+}
+
+// VerifyLogin2FA 登录第二步：校验验证码或恢复码，通过后 session 才算登录
+// 验证码输错与密码输错一样计入登录限流并写入审计记录，通过后才清除用户名的失败记录；
+// 次数保存在服务端，账号因此被锁定时作废这次登录，需要重新输入密码
+func VerifyLogin2FA(t *LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetPendingMFAUserID(r)
+		if !ok {
+			http.Error(w, "No login is waiting for two-factor authentication", http.StatusUnauthorized)
+			return
+		}
+
+		var req models.TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		username, _ := middleware.GetUsername(r)
+		attempt, wait, err := t.begin(username, middleware.ClientIP(r))
+		if err != nil {
+			log.Printf("Error checking login throttle: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		verified, err := verifySecondFactor(userID, req.Code)
+		if err != nil {
+			t.pass(attempt)
+			log.Printf("Error verifying second factor: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		session, _ := middleware.GetSession(r)
+		if !verified {
+			if t.fail(attempt, userID) {
+				session.Options.MaxAge = -1
+				if err := session.Save(r, w); err != nil {
+					log.Printf("Error deleting session: %v", err)
+				}
+				http.Error(w, "Too many failed attempts, please log in again", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+			return
+		}
+		t.succeed(attempt)
+
+		// 完成登录时再次更换 session ID
+		if err := middleware.SessionStore().Revoke(session.ID); err != nil {
+			log.Printf("Error revoking previous session: %v", err)
+		}
+		session.ID = ""
+		middleware.CompleteMFA(session)
+		if err := session.Save(r, w); err != nil {
+			log.Printf("Error saving session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := middleware.SessionStore().Touch(session.ID, middleware.ClientIP(r), r.UserAgent()); err != nil {
+			log.Printf("Error touching session: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Login successful",
+		})
+	}
+}
+
+// GetTwoFactorStatus 获取当前用户的两步验证状态
+func GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	var remaining int
+	err := database.DB.QueryRow(`
+		SELECT u.totp_enabled_at IS NOT NULL,
+		       (SELECT COUNT(*) FROM user_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		FROM users u
+		WHERE u.id = $1
+	`, userID).Scan(&enabled, &remaining)
+
+	if err != nil {
+		log.Printf("Error querying two-factor status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor 开始绑定两步验证：生成新的密钥，返回密钥和供认证器扫码的 URI
+// 密钥在 EnableTwoFactor 确认验证码之前不会生效
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var username string
+	err = database.DB.QueryRow(
+		"UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_enabled_at IS NULL RETURNING username",
+		secret, userID,
+	).Scan(&username)
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error saving TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(totpIssuer, username, secret),
+	})
+}
+
+// EnableTwoFactor 用认证器生成的验证码确认绑定，成功后返回恢复码（只返回这一次）
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	if err = tx.QueryRow(
+		"SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE",
+		userID,
+	).Scan(&secret, &enabled); err != nil {
+		log.Printf("Error querying TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !secret.Valid {
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(secret.String, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid verification code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = tx.Exec(
+		"UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = $1 WHERE id = $2",
+		step, userID,
+	); err != nil {
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = replaceRecoveryCodes(tx, userID, codes); err != nil {
+		log.Printf("Error saving recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+		"message":        "Two-factor authentication enabled",
+	})
+}
+
+// DisableTwoFactor 关闭两步验证，需要输入当前密码
+// 通过单点登录创建的账号没有密码，改为输入认证器中的验证码（或恢复码）；两种方式都计入登录限流
+func DisableTwoFactor(t *LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req models.DisableTwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var username, passwordHash string
+		if err := database.DB.QueryRow(
+			"SELECT username, password_hash FROM users WHERE id = $1",
+			userID,
+		).Scan(&username, &passwordHash); err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// 有密码的账号必须输入密码，只持有验证码或恢复码不能关闭两步验证
+		if passwordHash == "" && req.Code == "" {
+			http.Error(w, "Enter a verification code from your authenticator app", http.StatusBadRequest)
+			return
+		}
+
+		attempt, wait, err := t.begin(username, middleware.ClientIP(r))
+		if err != nil {
+			log.Printf("Error checking login throttle: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		if passwordHash == "" {
+			verified, err := verifySecondFactor(userID, req.Code)
+			if err != nil {
+				t.pass(attempt)
+				log.Printf("Error verifying second factor: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				t.fail(attempt, userID)
+				http.Error(w, "Invalid verification code", http.StatusForbidden)
+				return
+			}
+		} else if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+			t.fail(attempt, userID)
+			http.Error(w, "Incorrect password", http.StatusForbidden)
+			return
+		}
+		t.succeed(attempt)
+
+		tx, err := database.DB.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err = tx.Exec(
+			"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1",
+			userID,
+		); err != nil {
+			log.Printf("Error disabling two-factor authentication: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = replaceRecoveryCodes(tx, userID, nil); err != nil {
+			log.Printf("Error deleting recovery codes: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
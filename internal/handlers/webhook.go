@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"go-chat/internal/database"
+	"go-chat/internal/middleware"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"go-chat/internal/services/throttle"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxWebhookBodySize webhook 请求体的大小上限
+	maxWebhookBodySize = 64 << 10
+	// maxWebhookNameLength webhook 名称和显示名称的最大长度
+	maxWebhookNameLength = 100
+	// maxWebhookTextLength 消息正文的最大长度
+	maxWebhookTextLength = 4000
+	// maxEmbeds 每条消息最多的卡片数
+	maxEmbeds = 10
+	// maxEmbedTitleLength / maxEmbedTextLength 卡片标题和正文的最大长度
+	maxEmbedTitleLength = 256
+	maxEmbedTextLength  = 2000
+	// maxWebhooksPerRoom 每个房间最多的 webhook 数
+	maxWebhooksPerRoom = 20
+)
+
+// embedColorPattern 卡片颜色格式
+var embedColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// webhookColumns webhook 查询的列（room_webhooks 别名为 wh，users 别名为 u）
+const webhookColumns = `wh.id, wh.room_id, wh.name, wh.created_by, u.username, wh.last_used_at, wh.created_at`
+
+// scanWebhook 扫描一行 webhookColumns
+func scanWebhook(row rowScanner, webhook *models.Webhook) error {
+	return row.Scan(
+		&webhook.ID, &webhook.RoomID, &webhook.Name, &webhook.CreatedBy, &webhook.CreatorName,
+		&webhook.LastUsedAt, &webhook.CreatedAt,
+	)
+}
+
+// newWebhookToken 生成 webhook 密钥，返回密钥和它的 SHA-256
+func newWebhookToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, hashWebhookToken(token), nil
+}
+
+// hashWebhookToken 返回 webhook 密钥的 SHA-256
+func hashWebhookToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetRoomWebhooks 获取房间的 webhook（需要 ManageWebhooks 权限）
+func GetRoomWebhooks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageWebhooks); !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+webhookColumns+`
+		FROM room_webhooks wh INNER JOIN users u ON wh.created_by = u.id
+		WHERE wh.room_id = $1
+		ORDER BY wh.created_at
+	`, roomID)
+	if err != nil {
+		log.Printf("Error querying webhooks: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			log.Printf("Error scanning webhook: %v", err)
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// CreateWebhook 创建 webhook（需要 ManageWebhooks 权限），带密钥的 URL 只在响应中返回这一次
+// baseURL 为对外访问的地址，用于拼接完整的 URL
+func CreateWebhook(baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageWebhooks); !ok {
+			return
+		}
+
+		kind, err := getRoomKind(roomID)
+		if err != nil {
+			log.Printf("Error querying room kind: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if kind != models.RoomKindChannel {
+			http.Error(w, "Webhooks are only available in channels", http.StatusForbidden)
+			return
+		}
+
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		var req models.CreateWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > maxWebhookNameLength {
+			http.Error(w, "Webhook name must be 1-100 characters", http.StatusBadRequest)
+			return
+		}
+
+		var count int
+		if err := database.DB.QueryRow(
+			"SELECT COUNT(*) FROM room_webhooks WHERE room_id = $1", roomID,
+		).Scan(&count); err != nil {
+			log.Printf("Error counting webhooks: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxWebhooksPerRoom {
+			http.Error(w, "Webhook limit reached", http.StatusConflict)
+			return
+		}
+
+		token, tokenHash, err := newWebhookToken()
+		if err != nil {
+			log.Printf("Error generating webhook token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var webhook models.Webhook
+		err = scanWebhook(database.DB.QueryRow(`
+			WITH wh AS (
+				INSERT INTO room_webhooks (room_id, created_by, name, token_hash)
+				VALUES ($1, $2, $3, $4)
+				RETURNING *
+			)
+			SELECT `+webhookColumns+`
+			FROM wh INNER JOIN users u ON wh.created_by = u.id
+		`, roomID, userID, req.Name, tokenHash), &webhook)
+		if err != nil {
+			log.Printf("Error creating webhook: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"webhook": webhook,
+			"url":     strings.TrimRight(baseURL, "/") + "/hooks/" + token,
+			"message": "Webhook created, the URL will not be shown again",
+		})
+	}
+}
+
+// DeleteWebhook 删除 webhook（需要 ManageWebhooks 权限），已发送的消息保留
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+	webhookID, err := strconv.Atoi(vars["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := checkRoomPermission(w, roomID, userID, permissions.ManageWebhooks); !ok {
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM room_webhooks WHERE id = $1 AND room_id = $2", webhookID, roomID)
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Webhook deleted",
+	})
+}
+
+// validateEmbeds 校验并规整卡片，返回的错误信息可以直接返回给调用方
+func validateEmbeds(embeds []models.Embed) (string, bool) {
+	if len(embeds) > maxEmbeds {
+		return "Too many attachments", false
+	}
+	for i := range embeds {
+		e := &embeds[i]
+		e.Title = strings.TrimSpace(e.Title)
+		e.Text = strings.TrimSpace(e.Text)
+		e.TitleLink = strings.TrimSpace(e.TitleLink)
+
+		if e.Title == "" && e.Text == "" {
+			return "Attachment title or text is required", false
+		}
+		if utf8.RuneCountInString(e.Title) > maxEmbedTitleLength || utf8.RuneCountInString(e.Text) > maxEmbedTextLength {
+			return "Attachment is too long", false
+		}
+		// 只允许 http/https 链接，防止 javascript: 等链接在页面中执行；引号等字符可能破坏页面中的属性
+		if e.TitleLink != "" {
+			u, err := url.Parse(e.TitleLink)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+				strings.ContainsAny(e.TitleLink, "\"'<>` \t\r\n") {
+				return "Attachment title_link must be an http(s) URL", false
+			}
+		}
+		if e.Color != "" && !embedColorPattern.MatchString(e.Color) {
+			return "Attachment color must be in #rrggbb format", false
+		}
+	}
+	return "", true
+}
+
+// PostWebhookMessage 通过 incoming webhook 向房间发送消息，不需要登录，URL 中的密钥即凭据
+// 消息与普通消息一样保存并广播，带有集成标记；每个 webhook 按 limiter 限流
+func PostWebhookMessage(h *hub.Hub, limiter *throttle.RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		var webhookID, roomID, creatorID int
+		var name string
+		var creatorIsMember bool
+		err := database.DB.QueryRow(`
+			SELECT wh.id, wh.room_id, wh.created_by, wh.name,
+				EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = wh.room_id AND rm.user_id = wh.created_by)
+			FROM room_webhooks wh WHERE wh.token_hash = $1
+		`, hashWebhookToken(token)).Scan(&webhookID, &roomID, &creatorID, &name, &creatorIsMember)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error querying webhook: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if ok, wait := limiter.Allow(strconv.Itoa(webhookID)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		var payload models.WebhookPayload
+		r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBodySize)
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		payload.Text = strings.TrimSpace(payload.Text)
+		if payload.Text == "" && len(payload.Attachments) == 0 {
+			http.Error(w, "text or attachments is required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(payload.Text) > maxWebhookTextLength {
+			http.Error(w, "text is too long", http.StatusBadRequest)
+			return
+		}
+		if msg, ok := validateEmbeds(payload.Attachments); !ok {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		senderName := strings.TrimSpace(payload.Username)
+		if senderName == "" {
+			senderName = name
+		}
+		if utf8.RuneCountInString(senderName) > maxWebhookNameLength {
+			http.Error(w, "username is too long", http.StatusBadRequest)
+			return
+		}
+
+		// 消息以创建者的身份保存，创建者离开房间后 webhook 不再可用
+		if !creatorIsMember {
+			http.Error(w, "Webhook owner is no longer a member of this room", http.StatusForbidden)
+			return
+		}
+		if err := checkRoomWritable(roomID); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		// 与 WebSocket 发送的消息走同样的保存路径
+		msg := &models.Message{
+			RoomID:      roomID,
+			UserID:      creatorID,
+			Username:    senderName,
+			Content:     payload.Text,
+			CreatedAt:   time.Now(),
+			Integration: true,
+			WebhookID:   webhookID,
+			Embeds:      payload.Attachments,
+		}
+		if err := saveMessageToDB(h, msg); err != nil {
+			log.Printf("Error saving webhook message: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := database.DB.Exec(
+			"UPDATE room_webhooks SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", webhookID,
+		); err != nil {
+			log.Printf("Error updating webhook last use: %v", err)
+		}
+
+		h.BroadcastToRoom(roomID, models.WebSocketMessage{
+			Type:    "message",
+			RoomID:  roomID,
+			Message: msg,
+		}, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": msg.ID,
+		})
+	}
+}
@@ -1,28 +1,63 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
 	"go-chat/internal/database"
 	"go-chat/internal/middleware"
 	"go-chat/internal/models"
+	"go-chat/internal/services/apitoken"
 	"go-chat/internal/services/hub"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 生产环境中应该验证 origin
-	},
+// newUpgrader 创建检查 Origin 的 WebSocket upgrader，baseURL 为对外访问的地址
+func newUpgrader(baseURL string) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(r, baseURL)
+		},
+	}
+}
+
+// checkOrigin 判断是否允许建立 WebSocket 连接
+// 浏览器会自动带上 cookie，其他网站的页面也能以当前用户的身份连接，因此使用 cookie 登录时
+// Origin 必须与 baseURL 一致；API 令牌需要显式放在请求头中，不受跨站请求影响，不做检查。
+// 没有 Origin 头的请求不是来自浏览器页面，同样放行
+func checkOrigin(r *http.Request, baseURL string) bool {
+	if _, ok := middleware.GetToken(r); ok {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(o.Scheme, base.Scheme) && strings.EqualFold(o.Host, base.Host)
 }
 
-// HandleWebSocket 处理 WebSocket 连接
-func HandleWebSocket(h *hub.Hub) http.HandlerFunc {
+// HandleWebSocket 处理 WebSocket 连接，使用 cookie 登录时只接受来自 baseURL 的页面
+func HandleWebSocket(h *hub.Hub, baseURL string) http.HandlerFunc {
+	upgrader := newUpgrader(baseURL)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 获取房间 ID
 		vars := mux.Vars(r)
@@ -41,6 +76,12 @@ func HandleWebSocket(h *hub.Hub) http.HandlerFunc {
 
 		username, _ := middleware.GetUsername(r)
 
+		// 使用 API 令牌连接时，没有 write 权限的令牌只能接收消息
+		readOnly := false
+		if token, ok := middleware.GetToken(r); ok {
+			readOnly = !token.HasScope(apitoken.ScopeWrite)
+		}
+
 		// 检查用户是否是房间成员
 		var exists bool
 		err = database.DB.QueryRow(
@@ -62,27 +103,122 @@ func HandleWebSocket(h *hub.Hub) http.HandlerFunc {
 
 		// 创建客户端
 		client := &hub.Client{
-			Hub:      h,
-			Conn:     hub.NewConnection(conn),
-			RoomID:   roomID,
-			UserID:   userID,
-			Username: username,
-			Send:     make(chan []byte, 256),
+			Hub:       h,
+			Conn:      hub.NewConnection(conn),
+			RoomID:    roomID,
+			UserID:    userID,
+			Username:  username,
+			Send:      make(chan []byte, 256),
+			SessionID: middleware.CredentialID(r),
+			ReadOnly:  readOnly,
 		}
 
-		// 注册客户端
+		// 客户端带上 last_id 表示需要补发该消息之后错过的消息
+		if lastID := r.URL.Query().Get("last_id"); lastID != "" {
+			id, err := strconv.Atoi(lastID)
+			if err == nil && id >= 0 {
+				client.Resuming = true
+				client.LastMessageID = id
+			}
+		}
+
+		// 注册客户端，Register 返回时 Hub 已经开始为补发暂存实时消息
 		client.Hub.Register(client)
 
 		// 启动读写协程
 		go client.WritePump()
-		go client.ReadPump(saveMessageToDB)
+
+		// 注册之后再查询错过的消息，期间到达的实时消息由 Hub 暂存
+		if client.Resuming {
+			missed, err := loadMessagesAfter(roomID, client.LastMessageID, hub.MaxReplayMessages+1)
+			if err == nil {
+				err = client.Resume(missed)
+			}
+			if err != nil {
+				log.Printf("Error resuming session for user %d in room %d: %v", userID, roomID, err)
+				client.Hub.Unregister(client)
+				return
+			}
+		}
+
+		go client.ReadPump(messageStore{hub: h})
 	}
 }
 
+// loadMessagesAfter 按 ID 升序查询房间主消息流中 ID 大于 lastID 的消息（不含线程回复）
+func loadMessagesAfter(roomID, lastID, limit int) ([]models.Message, error) {
+	messages, _, err := queryRoomMessages(roomID, messagePage{After: lastID, Limit: limit})
+	return messages, err
+}
+
 // saveMessageToDB 保存消息到数据库
-func saveMessageToDB(msg *models.Message) error {
-	return database.DB.QueryRow(
-		"INSERT INTO messages (room_id, user_id, content, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
-		msg.RoomID, msg.UserID, msg.Content, msg.CreatedAt,
-	).Scan(&msg.ID)
+// 回复会被挂到线程根消息下并刷新线程统计；附带的附件在同一事务中关联到消息
+// 集成消息（msg.Integration）同时保存 webhook、显示名称和卡片；message.created 事件在同一事务中写入
+func saveMessageToDB(h *hub.Hub, msg *models.Message) error {
+	var webhookID sql.NullInt64
+	var senderName sql.NullString
+	var embeds []byte
+	if msg.Integration {
+		webhookID = sql.NullInt64{Int64: int64(msg.WebhookID), Valid: msg.WebhookID != 0}
+		senderName = sql.NullString{String: msg.Username, Valid: true}
+		if len(msg.Embeds) > 0 {
+			data, err := json.Marshal(msg.Embeds)
+			if err != nil {
+				return err
+			}
+			embeds = data
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	if msg.ParentID != 0 {
+		var rootID sql.NullInt64
+		err = tx.QueryRow(
+			"SELECT parent_id FROM messages WHERE id = $1 AND room_id = $2 AND deleted_at IS NULL FOR UPDATE",
+			msg.ParentID, msg.RoomID,
+		).Scan(&rootID)
+
+		if err == sql.ErrNoRows {
+			return errMessageNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if rootID.Valid {
+			msg.ParentID = int(rootID.Int64)
+		}
+		parentID = sql.NullInt64{Int64: int64(msg.ParentID), Valid: true}
+	}
+
+	if err = tx.QueryRow(`
+		INSERT INTO messages (room_id, user_id, content, created_at, parent_id, webhook_id, sender_name, embeds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id
+	`, msg.RoomID, msg.UserID, msg.Content, msg.CreatedAt, parentID, webhookID, senderName, embeds,
+	).Scan(&msg.ID); err != nil {
+		return err
+	}
+
+	if len(msg.AttachmentIDs) > 0 {
+		if err = linkAttachments(tx, msg); err != nil {
+			return err
+		}
+	}
+
+	if msg.ParentID != 0 {
+		if err = refreshThreadStats(tx, msg.ParentID); err != nil {
+			return err
+		}
+	}
+
+	if err = h.Emit(tx, msg.RoomID, models.EventMessageCreated, msg); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
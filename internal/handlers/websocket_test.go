@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	const baseURL = "https://chat.example.com"
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"same origin", "https://chat.example.com", true},
+		{"host is case-insensitive", "https://Chat.Example.com", true},
+		{"no origin header", "", true},
+		{"other site", "https://evil.example.com", false},
+		{"different scheme", "http://chat.example.com", false},
+		{"different port", "https://chat.example.com:8443", false},
+		{"malformed origin", "://", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws/rooms/1", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(r, baseURL); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,38 +1,125 @@
 package middleware
 
 import (
+	"go-chat/internal/services/session"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/sessions"
 )
 
-var store = sessions.NewCookieStore([]byte("your-secret-key-change-this-in-production"))
+// sessionName session cookie 的名称
+const sessionName = "session"
 
-// RequireAuth 要求用户必须登录
+// mfaPendingKey session 中的该值为 true 表示密码已验证，但两步验证尚未完成
+const mfaPendingKey = "mfa_pending"
+
+// mfaPendingMaxAge 等待两步验证的 session 有效期（秒）
+const mfaPendingMaxAge = 5 * 60
+
+// store 全局共享的 session 存储，由 main 在启动时通过 SetSessionStore 设置
+var store *session.Store
+
+// SetSessionStore 设置全局 session 存储
+func SetSessionStore(s *session.Store) {
+	store = s
+}
+
+// SessionStore 返回全局 session 存储
+func SessionStore() *session.Store {
+	return store
+}
+
+// GetSession 获取当前请求的 session，session 无效时返回新的空 session
+func GetSession(r *http.Request) (*sessions.Session, error) {
+	return store.Get(r, sessionName)
+}
+
+// RequireAuth 要求用户必须登录：带 Authorization: Bearer 的请求使用 API 令牌认证，其余请求使用 cookie 登录
+// 未登录时页面请求跳转到登录页，API 请求（/api/、/ws/ 或带 Authorization 头）返回 401 JSON
 func RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, _ := store.Get(r, "session")
-		userID, ok := session.Values["user_id"]
+		if r.Header.Get("Authorization") != "" {
+			authenticateBearer(w, r, next)
+			return
+		}
+
+		session, err := GetSession(r)
+		if err != nil {
+			log.Printf("Error loading session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
-		if !ok || userID == nil {
+		if _, ok := session.Values["user_id"].(int); !ok {
+			if wantsJSON(r) {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
+		// 开启了两步验证的用户必须完成第二步才能访问
+		if pending, _ := session.Values[mfaPendingKey].(bool); pending {
+			if wantsJSON(r) {
+				writeJSONError(w, http.StatusUnauthorized, "mfa_required", "Two-factor authentication required")
+				return
+			}
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+			return
+		}
+
+		// 记录最近活动，供会话管理页面展示
+		if err := store.Touch(session.ID, ClientIP(r), r.UserAgent()); err != nil {
+			log.Printf("Error touching session: %v", err)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// GetUserID 从 session 中获取用户 ID
+// GetUserID 获取当前用户 ID（API 令牌代表的用户或 session 中的用户），两步验证尚未完成时视为未登录
 func GetUserID(r *http.Request) (int, bool) {
-	session, _ := store.Get(r, "session")
+	if identity, ok := GetToken(r); ok {
+		return identity.UserID, true
+	}
+	session, _ := GetSession(r)
+	if pending, _ := session.Values[mfaPendingKey].(bool); pending {
+		return 0, false
+	}
 	userID, ok := session.Values["user_id"].(int)
 	return userID, ok
 }
 
-// GetUsername 从 session 中获取用户名
+// GetPendingMFAUserID 获取已通过密码验证、等待两步验证的用户 ID
+func GetPendingMFAUserID(r *http.Request) (int, bool) {
+	session, _ := GetSession(r)
+	if pending, _ := session.Values[mfaPendingKey].(bool); !pending {
+		return 0, false
+	}
+	userID, ok := session.Values["user_id"].(int)
+	return userID, ok
+}
+
+// BeginMFA 标记 session 需要完成两步验证，在此之前 session 只短时间有效
+func BeginMFA(s *sessions.Session) {
+	s.Values[mfaPendingKey] = true
+	s.Options.MaxAge = mfaPendingMaxAge
+}
+
+// CompleteMFA 标记 session 已完成两步验证，恢复正常的有效期
+func CompleteMFA(s *sessions.Session) {
+	delete(s.Values, mfaPendingKey)
+	s.Options.MaxAge = store.Options.MaxAge
+}
+
+// GetUsername 获取当前用户名
 func GetUsername(r *http.Request) (string, bool) {
-	session, _ := store.Get(r, "session")
+	if identity, ok := GetToken(r); ok {
+		return identity.Username, true
+	}
+	session, _ := GetSession(r)
 	username, ok := session.Values["username"].(string)
 	return username, ok
 }
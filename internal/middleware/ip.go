@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClientIP 返回请求的客户端 IP
+// TRUST_PROXY_HEADERS=true 时从 X-Forwarded-For / X-Real-IP 读取，
+// 只应在服务部署在可信的反向代理之后时开启，否则客户端可以伪造 IP
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if ip := forwardedFor(r, trustedProxyHops()); ip != "" {
+			return ip
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustedProxyHops 读取 TRUSTED_PROXY_HOPS：客户端与服务之间可信反向代理的层数，默认为 1
+func trustedProxyHops() int {
+	hops, err := strconv.Atoi(os.Getenv("TRUSTED_PROXY_HOPS"))
+	if err != nil || hops < 1 {
+		return 1
+	}
+	return hops
+}
+
+// forwardedFor 从 X-Forwarded-For 中取出客户端 IP，无法确定时返回空
+// 每层代理把连接对端的地址追加到末尾，只有最右边 hops 个地址由可信代理写入；
+// 更靠左的地址由客户端自己填写，不能用于限流和审计
+func forwardedFor(r *http.Request, hops int) string {
+	var entries []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+	if len(entries) < hops {
+		return ""
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-hops]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
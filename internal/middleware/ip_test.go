@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     string
+		hops      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{name: "proxy headers ignored by default", forwarded: []string{"203.0.113.9"}, realIP: "203.0.113.9", want: "192.0.2.1"},
+		{name: "single proxy uses rightmost entry", trust: "true", forwarded: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed leftmost entry ignored", trust: "true", forwarded: []string{"1.2.3.4, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "multiple headers are joined", trust: "true", forwarded: []string{"1.2.3.4", "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "two trusted hops", trust: "true", hops: "2", forwarded: []string{"1.2.3.4, 203.0.113.9, 10.0.0.2"}, want: "203.0.113.9"},
+		{name: "fewer entries than hops falls back to X-Real-IP", trust: "true", hops: "2", forwarded: []string{"203.0.113.9"}, realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "invalid entry falls back to connection", trust: "true", forwarded: []string{"1.2.3.4, not-an-ip"}, want: "192.0.2.1"},
+		{name: "X-Real-IP without X-Forwarded-For", trust: "true", realIP: "198.51.100.7", want: "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY_HEADERS", tt.trust)
+			t.Setenv("TRUSTED_PROXY_HOPS", tt.hops)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:4321"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"go-chat/internal/services/apitoken"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tokens 全局共享的 API 令牌存储，由 main 在启动时通过 SetTokenStore 设置
+var tokens *apitoken.Store
+
+// identityKey 令牌认证的身份在请求 context 中的 key
+type identityKey struct{}
+
+// SetTokenStore 设置全局 API 令牌存储
+func SetTokenStore(s *apitoken.Store) {
+	tokens = s
+}
+
+// TokenStore 返回全局 API 令牌存储
+func TokenStore() *apitoken.Store {
+	return tokens
+}
+
+// GetToken 获取通过 API 令牌认证的身份，使用 cookie 登录时返回 false
+func GetToken(r *http.Request) (*apitoken.Identity, bool) {
+	identity, ok := r.Context().Value(identityKey{}).(*apitoken.Identity)
+	return identity, ok
+}
+
+// TokenCredentialID 返回 API 令牌对应的连接凭据 ID，撤销令牌时据此断开 WebSocket 连接
+func TokenCredentialID(tokenID int) string {
+	return "token:" + strconv.Itoa(tokenID)
+}
+
+// CredentialID 返回当前请求使用的凭据：API 令牌或登录 session 的 ID
+func CredentialID(r *http.Request) string {
+	if identity, ok := GetToken(r); ok {
+		return TokenCredentialID(identity.TokenID)
+	}
+	session, _ := GetSession(r)
+	return session.ID
+}
+
+// authenticateBearer 处理带 Authorization: Bearer 的请求
+// 令牌无效时返回 401，权限不足时返回 403，不会再尝试 cookie 登录
+func authenticateBearer(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "invalid_token", "Authorization header must use the Bearer scheme")
+		return
+	}
+
+	identity, err := tokens.Authenticate(strings.TrimSpace(raw))
+	if err == apitoken.ErrInvalid {
+		writeJSONError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid, expired or revoked")
+		return
+	} else if err != nil {
+		log.Printf("Error authenticating API token: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "Internal server error")
+		return
+	}
+
+	// 只读请求需要 read 权限，其余请求需要 write 权限
+	scope := apitoken.ScopeWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = apitoken.ScopeRead
+	}
+	if !identity.HasScope(scope) {
+		writeJSONError(w, http.StatusForbidden, "insufficient_scope", "The access token requires the "+scope+" scope")
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+}
+
+// SessionOnly 只允许通过 cookie 登录访问，用于管理令牌、session 和两步验证等账号操作
+// 泄露的 API 令牌不能用来创建新令牌或修改账号安全设置
+func SessionOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetToken(r); ok {
+			writeJSONError(w, http.StatusForbidden, "session_required", "This endpoint cannot be used with an API token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// wantsJSON 判断请求来自 API 客户端而不是浏览器页面跳转，认证失败时返回 JSON 而不是重定向
+func wantsJSON(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" ||
+		strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.HasPrefix(r.URL.Path, "/ws/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeJSONError 返回 JSON 格式的错误 {"error": code, "message": message}
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+code+`"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   code,
+		"message": message,
+	})
+}
@@ -1,34 +1,103 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User 用户模型
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` // 不在 JSON 中显示密码
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	PasswordHash    string     `json:"-"`                 // 不在 JSON 中显示密码
+	EmailVerifiedAt *time.Time `json:"email_verified_at"` // 邮箱验证时间，未验证时为空
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // Session 会话模型
+// ID 和 Data 不会返回给前端，会话管理接口使用 PublicID 标识 session
 type Session struct {
-	ID        string    `json:"id"`
-	UserID    int       `json:"user_id"`
-	Data      string    `json:"data"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string     `json:"-"`
+	PublicID   int64      `json:"id"`
+	UserID     *int       `json:"user_id"` // 未登录的 session 为空
+	Data       string     `json:"-"`       // gob 序列化后 base64 编码的 session 数据
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Current    bool       `json:"current"` // 是否是发出请求的 session
 }
 
+// 房间类型
+const (
+	RoomKindChannel = "channel"  // 普通聊天室
+	RoomKindDM      = "dm"       // 一对一私聊
+	RoomKindGroupDM = "group_dm" // 多人私聊
+)
+
+// 房间可见性，只有普通聊天室可以公开
+const (
+	RoomVisibilityPrivate = "private" // 仅能通过邀请加入
+	RoomVisibilityPublic  = "public"  // 出现在房间目录中，任何人都可以加入
+	RoomVisibilityRequest = "request" // 出现在房间目录中，申请后经审批加入
+)
+
+// 加入申请状态
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestDenied   = "denied"
+)
+
 // Room 聊天室模型
 type Room struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatorID   int       `json:"creator_id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"` // 私聊房间为其他成员的用户名
+	Description string     `json:"description"`
+	Kind        string     `json:"kind"`
+	Visibility  string     `json:"visibility"`
+	CreatorID   int        `json:"creator_id"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"` // 非空表示房间已归档（只读）
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// PublicRoom 房间目录中的公开房间
+type PublicRoom struct {
+	Room
+	MemberCount int  `json:"member_count"`
+	Joined      bool `json:"joined"`    // 当前用户是否已加入
+	Requested   bool `json:"requested"` // 当前用户是否有待审批的加入申请
+}
+
+// JoinRequest 房间加入申请
+type JoinRequest struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Message   string     `json:"message"`
+	Status    string     `json:"status"`
+	DecidedBy *int       `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RoomInvite 房间邀请链接
+type RoomInvite struct {
+	ID          int        `json:"id"`
+	RoomID      int        `json:"room_id"`
+	Token       string     `json:"token"`
+	URL         string     `json:"url"`
+	CreatedBy   int        `json:"created_by"`
+	CreatorName string     `json:"creator_name"`
+	MaxUses     *int       `json:"max_uses"` // 为空表示不限次数
+	Uses        int        `json:"uses"`
+	ExpiresAt   *time.Time `json:"expires_at"` // 为空表示不过期
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 // RoomMember 房间成员模型
@@ -36,17 +105,215 @@ type RoomMember struct {
 	ID       int       `json:"id"`
 	RoomID   int       `json:"room_id"`
 	UserID   int       `json:"user_id"`
-	Role     string    `json:"role"` // "creator" 或 "member"
+	Role     string    `json:"role"` // creator、admin、moderator 或 member
 	JoinedAt time.Time `json:"joined_at"`
 }
 
 // Message 消息模型
 type Message struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"` // 用于显示
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"` // 最后一次编辑时间，未编辑为空
+
+	// 线程回复：ParentID 为所回复的消息，ReplyCount/LastReplyAt 只在父消息上有值
+	ParentID    int        `json:"parent_id,omitempty"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+
+	Reactions []Reaction `json:"reactions,omitempty"` // 按表情聚合的回应
+
+	// 置顶：PinnedAt 非空表示消息已被 PinnedBy 置顶
+	PinnedAt *time.Time `json:"pinned_at,omitempty"`
+	PinnedBy int        `json:"pinned_by,omitempty"`
+
+	Attachments   []Attachment `json:"attachments,omitempty"`
+	AttachmentIDs []int        `json:"-"` // 发送消息时要关联的已上传附件
+
+	// 集成消息：Integration 为 true 时消息由 incoming webhook 发送，Username 为集成指定的显示名称
+	// webhook 被删除后 WebhookID 为 0，Integration 仍为 true
+	Integration bool    `json:"integration,omitempty"`
+	WebhookID   int     `json:"webhook_id,omitempty"`
+	Embeds      []Embed `json:"embeds,omitempty"`
+}
+
+// Embed 集成消息中的卡片
+type Embed struct {
+	Title     string `json:"title,omitempty"`
+	TitleLink string `json:"title_link,omitempty"` // 只允许 http/https 链接
+	Text      string `json:"text,omitempty"`
+	Color     string `json:"color,omitempty"` // 左侧色条，#rrggbb
+}
+
+// Attachment 消息附件
+type Attachment struct {
+	ID           int       `json:"id"`
+	RoomID       int       `json:"room_id"`
+	MessageID    int       `json:"message_id,omitempty"`
+	UploaderID   int       `json:"uploader_id"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`  // 图片宽度
+	Height       int       `json:"height,omitempty"` // 图片高度
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Reaction 某条消息上某个表情的聚合回应
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []int  `json:"user_ids"` // 回应过的用户，按回应时间排序
+}
+
+// Thread 消息线程
+type Thread struct {
+	Parent  Message   `json:"parent"`
+	Replies []Message `json:"replies"`
+}
+
+// MessageEdit 消息编辑历史
+type MessageEdit struct {
+	ID         int       `json:"id"`
+	MessageID  int       `json:"message_id"`
+	EditorID   int       `json:"editor_id"`
+	EditorName string    `json:"editor_name"`
+	Action     string    `json:"action"` // "edit" 或 "delete"
+	OldContent string    `json:"old_content"`
+	NewContent string    `json:"new_content,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SearchResult 消息搜索结果
+type SearchResult struct {
+	Message  Message `json:"message"`
+	RoomName string  `json:"room_name"`
+	Snippet  string  `json:"snippet"` // 已转义的 HTML 片段，匹配词用 <mark> 标出
+}
+
+// APIToken API 令牌（不包含令牌本身，令牌只在创建时返回一次）
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`  // 令牌代表的用户（本人或其机器人）
+	Username   string     `json:"username"` // 令牌代表的用户名
+	IsBot      bool       `json:"is_bot"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Bot 机器人账号
+type Bot struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook 房间的 incoming webhook（不包含密钥，URL 只在创建时返回一次）
+type Webhook struct {
+	ID          int        `json:"id"`
+	RoomID      int        `json:"room_id"`
+	Name        string     `json:"name"`
+	CreatedBy   int        `json:"created_by"`
+	CreatorName string     `json:"creator_name"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CreateWebhookRequest 创建 webhook 请求
+type CreateWebhookRequest struct {
+	Name string `json:"name"`
+}
+
+// WebhookPayload 通过 incoming webhook 发送消息的请求体
+// Username 为空时使用 webhook 的名称，Attachments 为卡片
+type WebhookPayload struct {
+	Text        string  `json:"text"`
+	Username    string  `json:"username"`
+	Attachments []Embed `json:"attachments"`
+}
+
+// 房间事件类型，外部系统可以通过事件订阅接收
+const (
+	EventMessageCreated = "message.created"
+	EventMemberJoined   = "member.joined"
+	EventMemberRemoved  = "member.removed"
+	EventRoomUpdated    = "room.updated"
+)
+
+// 事件投递状态
+const (
+	DeliveryPending   = "pending"   // 等待投递或重试
+	DeliverySucceeded = "succeeded" // 订阅方返回了 2xx
+	DeliveryDead      = "dead"      // 重试次数用完，进入死信，可以手动重新投递
+)
+
+// MemberEvent member.joined / member.removed 事件的数据
+type MemberEvent struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	ActorID  int    `json:"actor_id,omitempty"` // 邀请、审批或移除该成员的用户，自己加入或离开时为 0
+	Reason   string `json:"reason"`             // joined: invited、invite_link、public、approved；removed: removed、left
+}
+
+// EventSubscription 房间的事件订阅（不包含签名密钥，密钥只在创建时返回一次）
+type EventSubscription struct {
+	ID          int       `json:"id"`
+	RoomID      int       `json:"room_id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	CreatedBy   int       `json:"created_by"`
+	CreatorName string    `json:"creator_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateEventSubscriptionRequest 创建事件订阅请求
+type CreateEventSubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// EventDelivery 一个事件对某个订阅的投递
+type EventDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"` // 只有 pending 状态有值
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// DeliveryAttempt 一次投递尝试的日志
+type DeliveryAttempt struct {
+	ID         int64     `json:"id"`
+	StatusCode *int      `json:"status_code"` // 没有收到响应时为空
+	Error      string    `json:"error"`
+	DurationMS int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// LoginAuditEntry 登录审计记录
+type LoginAuditEntry struct {
 	ID        int       `json:"id"`
-	RoomID    int       `json:"room_id"`
-	UserID    int       `json:"user_id"`
-	Username  string    `json:"username"` // 用于显示
-	Content   string    `json:"content"`
+	UserID    *int      `json:"user_id"`  // 用户名不存在时为空
+	Username  string    `json:"username"` // 尝试登录时填写的用户名（小写）
+	IP        string    `json:"ip"`
+	Event     string    `json:"event"`    // failure、lockout 或 unlock
+	ActorID   *int      `json:"actor_id"` // 解锁操作的管理员
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -63,10 +330,52 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// EmailRequest 只包含邮箱的请求（重发验证邮件、忘记密码）
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest 重置密码请求，Token 来自重置密码邮件中的链接
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// TwoFactorCodeRequest 提交两步验证码的请求，登录时也可以填写恢复码
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTwoFactorRequest 关闭两步验证请求，有密码的账号填写当前密码，没有密码的单点登录账号填写验证码（恢复码）
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// CreateAPITokenRequest 创建 API 令牌请求
+// BotID 不为 0 时为自己的机器人创建令牌；ExpiresInDays 为 0 表示永不过期
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	BotID         int      `json:"bot_id"`
+}
+
+// CreateBotRequest 创建机器人请求
+type CreateBotRequest struct {
+	Username string `json:"username"`
+}
+
 // CreateRoomRequest 创建房间请求
 type CreateRoomRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Visibility  string `json:"visibility"` // 为空时为 private
+}
+
+// EditMessageRequest 编辑消息请求
+type EditMessageRequest struct {
+	Content string `json:"content"`
 }
 
 // InviteMemberRequest 邀请成员请求
@@ -74,13 +383,71 @@ type InviteMemberRequest struct {
 	Username string `json:"username"`
 }
 
+// UpdateRoomRequest 修改房间设置请求
+type UpdateRoomRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"` // 为空时保持不变
+}
+
+// CreateInviteRequest 创建邀请链接请求，字段为 0 表示不限制
+type CreateInviteRequest struct {
+	MaxUses   int `json:"max_uses"`
+	ExpiresIn int `json:"expires_in"` // 有效期（秒）
+}
+
+// CreateJoinRequestRequest 申请加入房间请求
+type CreateJoinRequestRequest struct {
+	Message string `json:"message"`
+}
+
+// ChangeRoleRequest 修改成员角色请求
+type ChangeRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// TransferOwnershipRequest 转让房间请求
+type TransferOwnershipRequest struct {
+	Username string `json:"username"`
+}
+
+// OpenDMRequest 打开私聊请求，只有一个用户时为一对一私聊，多个用户时创建多人私聊
+type OpenDMRequest struct {
+	Username  string   `json:"username"`
+	Usernames []string `json:"usernames"`
+}
+
+// OnlineUser 房间在线用户
+type OnlineUser struct {
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	Connections int    `json:"connections"` // 同一用户打开的连接数
+}
+
 // WebSocketMessage WebSocket 消息
 type WebSocketMessage struct {
-	Type    string      `json:"type"` // "message", "join", "leave", "error"
-	RoomID  int         `json:"room_id,omitempty"`
-	Message *Message    `json:"message,omitempty"`
-	Content string      `json:"content,omitempty"`
-	Error   string      `json:"error,omitempty"`
-	UserID  int         `json:"user_id,omitempty"`
-	Username string     `json:"username,omitempty"`
+	Type     string       `json:"type"` // "message", "reply", "message_edit", "message_delete", "reaction_add", "reaction_remove", "join", "leave", "presence", "resumed", "resync", "error"
+	RoomID   int          `json:"room_id,omitempty"`
+	Message  *Message     `json:"message,omitempty"`
+	Content  string       `json:"content,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	UserID   int          `json:"user_id,omitempty"`
+	Username string       `json:"username,omitempty"`
+	Users    []OnlineUser `json:"users,omitempty"` // presence 快照中的在线用户
+
+	LastMessageID int `json:"last_message_id,omitempty"` // resumed: 补发完成后客户端已收到的最后一条消息 ID
+	MessageID     int `json:"message_id,omitempty"`      // message_edit / message_delete 的目标消息 ID
+
+	ParentID int      `json:"parent_id,omitempty"` // reply: 客户端发送时指定所回复的消息
+	Parent   *Message `json:"parent,omitempty"`    // reply / message_delete: 更新后的父消息（回复数等）
+
+	AttachmentIDs []int `json:"attachment_ids,omitempty"` // message / reply: 客户端发送时附带的已上传附件
+
+	Emoji     string     `json:"emoji,omitempty"`     // reaction_add / reaction_remove 的表情
+	Reactions []Reaction `json:"reactions,omitempty"` // reaction_add / reaction_remove: 消息更新后的全部回应
+
+	Role string `json:"role,omitempty"` // role_changed: 成员的新角色
+	Room *Room  `json:"room,omitempty"` // room_updated: 修改后的房间信息
+
+	JoinRequest *JoinRequest `json:"join_request,omitempty"` // join_request / join_request_decided: 加入申请
 }
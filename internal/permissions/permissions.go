@@ -0,0 +1,113 @@
+package permissions
+
+// Role 房间成员角色
+type Role string
+
+const (
+	RoleCreator   Role = "creator"   // 房间创建者，拥有全部权限
+	RoleAdmin     Role = "admin"     // 管理员
+	RoleModerator Role = "moderator" // 协管员
+	RoleMember    Role = "member"    // 普通成员
+)
+
+// Action 需要权限检查的房间操作
+type Action string
+
+const (
+	Invite               Action = "invite"                 // 邀请成员
+	Kick                 Action = "kick"                   // 移除成员
+	Pin                  Action = "pin"                    // 置顶消息
+	DeleteOthersMessages Action = "delete_others_messages" // 删除他人的消息，查看编辑历史
+	EditRoomSettings     Action = "edit_room_settings"     // 修改房间名称、描述和可见性
+	ApproveJoinRequests  Action = "approve_join_requests"  // 审批加入申请
+	ManageRoles          Action = "manage_roles"           // 修改成员角色
+	ManageRoom           Action = "manage_room"            // 归档、删除、转让房间
+	ManageWebhooks       Action = "manage_webhooks"        // 创建、删除 incoming webhook
+	ManageEventSubs      Action = "manage_event_subs"      // 管理事件订阅（outgoing webhook），查看投递日志
+)
+
+// rank 角色等级，等级高的角色才能管理等级低的成员
+var rank = map[Role]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+	RoleCreator:   4,
+}
+
+// grants 每个角色拥有的权限
+var grants = map[Role]map[Action]bool{
+	RoleCreator: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true,
+		EditRoomSettings: true, ApproveJoinRequests: true, ManageRoles: true, ManageRoom: true,
+		ManageWebhooks: true, ManageEventSubs: true,
+	},
+	RoleAdmin: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true,
+		EditRoomSettings: true, ApproveJoinRequests: true, ManageEventSubs: true,
+	},
+	RoleModerator: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true,
+	},
+	RoleMember: {},
+}
+
+// descriptions 用于错误提示的操作描述
+var descriptions = map[Action]string{
+	Invite:               "invite members",
+	Kick:                 "remove members",
+	Pin:                  "pin messages",
+	DeleteOthersMessages: "delete other members' messages",
+	EditRoomSettings:     "edit room settings",
+	ApproveJoinRequests:  "review join requests",
+	ManageRoles:          "change member roles",
+	ManageRoom:           "manage the room",
+	ManageWebhooks:       "manage webhooks",
+	ManageEventSubs:      "manage event subscriptions",
+}
+
+// Valid 判断是否是已知角色
+func Valid(role Role) bool {
+	_, ok := rank[role]
+	return ok
+}
+
+// Assignable 判断角色能否通过修改角色接口授予（创建者只能通过转让产生）
+func Assignable(role Role) bool {
+	return Valid(role) && role != RoleCreator
+}
+
+// Can 判断角色是否拥有某项权限，未知角色没有任何权限
+func Can(role Role, action Action) bool {
+	return grants[role][action]
+}
+
+// CanActOn 判断 actor 能否对角色为 target 的成员执行操作（如移除成员、删除其消息）
+// 除了拥有该权限外，actor 的等级必须高于 target；已离开房间的成员 target 为空
+func CanActOn(actor, target Role, action Action) bool {
+	return Can(actor, action) && rank[actor] > rank[target]
+}
+
+// RolesWith 返回拥有某项权限的全部角色，用于查询有权处理某事的成员
+func RolesWith(action Action) []Role {
+	var roles []Role
+	for role, actions := range grants {
+		if actions[action] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Allowed 返回角色拥有的全部权限（key 为 Action 的字符串值），供前端决定显示哪些操作
+func Allowed(role Role) map[string]bool {
+	allowed := make(map[string]bool, len(grants[role]))
+	for action := range grants[role] {
+		allowed[string(action)] = true
+	}
+	return allowed
+}
+
+// Denied 返回权限不足时的错误提示
+func Denied(action Action) string {
+	return "You do not have permission to " + descriptions[action]
+}
@@ -0,0 +1,145 @@
+package permissions
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// actions 全部操作，新增操作时需要同时补充下面的权限矩阵
+var actions = []Action{
+	Invite, Kick, Pin, DeleteOthersMessages, EditRoomSettings,
+	ApproveJoinRequests, ManageRoles, ManageRoom, ManageWebhooks, ManageEventSubs,
+}
+
+// matrix 期望的角色 × 操作权限矩阵
+var matrix = map[Role]map[Action]bool{
+	RoleCreator: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true, EditRoomSettings: true,
+		ApproveJoinRequests: true, ManageRoles: true, ManageRoom: true, ManageWebhooks: true, ManageEventSubs: true,
+	},
+	RoleAdmin: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true, EditRoomSettings: true,
+		ApproveJoinRequests: true, ManageRoles: false, ManageRoom: false, ManageWebhooks: false, ManageEventSubs: true,
+	},
+	RoleModerator: {
+		Invite: true, Kick: true, Pin: true, DeleteOthersMessages: true, EditRoomSettings: false,
+		ApproveJoinRequests: false, ManageRoles: false, ManageRoom: false, ManageWebhooks: false, ManageEventSubs: false,
+	},
+	RoleMember: {
+		Invite: false, Kick: false, Pin: false, DeleteOthersMessages: false, EditRoomSettings: false,
+		ApproveJoinRequests: false, ManageRoles: false, ManageRoom: false, ManageWebhooks: false, ManageEventSubs: false,
+	},
+	// 未知角色（例如已离开房间的成员）没有任何权限
+	"":      {},
+	"owner": {},
+}
+
+func TestCan(t *testing.T) {
+	for role, want := range matrix {
+		for _, action := range actions {
+			if got := Can(role, action); got != want[action] {
+				t.Errorf("Can(%q, %q) = %v, want %v", role, action, got, want[action])
+			}
+		}
+	}
+}
+
+func TestCanActOn(t *testing.T) {
+	tests := []struct {
+		actor, target Role
+		action        Action
+		want          bool
+	}{
+		// 等级更高且拥有权限
+		{RoleCreator, RoleAdmin, Kick, true},
+		{RoleCreator, RoleMember, ManageRoles, true},
+		{RoleAdmin, RoleModerator, Kick, true},
+		{RoleAdmin, RoleMember, DeleteOthersMessages, true},
+		{RoleModerator, RoleMember, Kick, true},
+		// 已离开房间的成员角色为空，任何拥有权限的角色都可以处理其消息
+		{RoleModerator, "", DeleteOthersMessages, true},
+		// 不能对同级成员操作
+		{RoleCreator, RoleCreator, Kick, false},
+		{RoleAdmin, RoleAdmin, Kick, false},
+		{RoleModerator, RoleModerator, DeleteOthersMessages, false},
+		// 不能对更高等级的成员操作
+		{RoleAdmin, RoleCreator, Kick, false},
+		{RoleModerator, RoleAdmin, Kick, false},
+		{RoleMember, RoleModerator, Kick, false},
+		// 等级更高但没有该权限
+		{RoleAdmin, RoleMember, ManageRoles, false},
+		{RoleModerator, RoleMember, EditRoomSettings, false},
+		{RoleMember, "", DeleteOthersMessages, false},
+		// 未知角色
+		{"owner", RoleMember, Kick, false},
+		{"", "", Kick, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanActOn(tt.actor, tt.target, tt.action); got != tt.want {
+			t.Errorf("CanActOn(%q, %q, %q) = %v, want %v", tt.actor, tt.target, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestRolesWith(t *testing.T) {
+	for _, action := range actions {
+		var want []string
+		for _, role := range []Role{RoleCreator, RoleAdmin, RoleModerator, RoleMember} {
+			if matrix[role][action] {
+				want = append(want, string(role))
+			}
+		}
+
+		var got []string
+		for _, role := range RolesWith(action) {
+			got = append(got, string(role))
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("RolesWith(%q) = %v, want %v", action, got, want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	for role, want := range matrix {
+		allowed := Allowed(role)
+		for _, action := range actions {
+			if allowed[string(action)] != want[action] {
+				t.Errorf("Allowed(%q)[%q] = %v, want %v", role, action, allowed[string(action)], want[action])
+			}
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	tests := []struct {
+		role Role
+		want bool
+	}{
+		{RoleCreator, false},
+		{RoleAdmin, true},
+		{RoleModerator, true},
+		{RoleMember, true},
+		{"owner", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := Assignable(tt.role); got != tt.want {
+			t.Errorf("Assignable(%q) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestDenied(t *testing.T) {
+	for _, action := range actions {
+		if msg := Denied(action); strings.HasSuffix(msg, "to ") {
+			t.Errorf("Denied(%q) has no description", action)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"go-chat/internal/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Prefix 令牌的固定前缀，便于在日志和代码仓库中识别泄露的令牌
+const Prefix = "gct_"
+
+// 令牌权限
+const (
+	ScopeRead  = "read"  // GET 请求和通过 WebSocket 接收消息
+	ScopeWrite = "write" // 其余请求和通过 WebSocket 发送消息
+)
+
+// usedInterval 更新 last_used_at 的最小间隔
+const usedInterval = time.Minute
+
+// ErrInvalid 令牌不存在、已过期或已撤销
+var ErrInvalid = errors.New("invalid, expired or revoked token")
+
+// ValidScope 判断是否是已知的权限
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// Identity 通过令牌认证的身份
+type Identity struct {
+	TokenID  int
+	UserID   int
+	Username string
+	IsBot    bool
+	Scopes   []string
+}
+
+// HasScope 判断令牌是否拥有某项权限
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store 基于 api_tokens 表的令牌存储
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore 创建 Store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create 为 userID 创建令牌，返回令牌本身（只有这一次机会获取）和令牌信息
+func (s *Store) Create(userID, createdBy int, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	raw := Prefix + hex.EncodeToString(buf)
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:len(Prefix)+6],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err := s.db.QueryRow(`
+		WITH inserted AS (
+			INSERT INTO api_tokens (user_id, created_by, name, token_hash, prefix, scopes, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, user_id, created_at
+		)
+		SELECT i.id, i.created_at, u.username, u.is_bot
+		FROM inserted i INNER JOIN users u ON u.id = i.user_id
+	`, userID, createdBy, name, hash(raw), token.Prefix, pq.Array(scopes), expiresAt,
+	).Scan(&token.ID, &token.CreatedAt, &token.Username, &token.IsBot)
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+// Authenticate 校验令牌并返回其代表的身份
+func (s *Store) Authenticate(raw string) (*Identity, error) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return nil, ErrInvalid
+	}
+
+	var identity Identity
+	err := s.db.QueryRow(`
+		SELECT t.id, u.id, u.username, u.is_bot, t.scopes
+		FROM api_tokens t
+		INNER JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL
+		  AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP)
+	`, hash(raw)).Scan(&identity.TokenID, &identity.UserID, &identity.Username, &identity.IsBot, pq.Array(&identity.Scopes))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalid
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec(`
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+	`, identity.TokenID, usedInterval.Seconds()); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// List 返回用户创建的、未撤销的令牌（包括为其机器人创建的令牌）
+func (s *Store) List(createdBy int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.user_id, u.username, u.is_bot, t.name, t.prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM api_tokens t
+		INNER JOIN users u ON u.id = t.user_id
+		WHERE t.created_by = $1 AND t.revoked_at IS NULL
+		ORDER BY t.created_at DESC
+	`, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		if err := rows.Scan(
+			&t.ID, &t.UserID, &t.Username, &t.IsBot, &t.Name, &t.Prefix,
+			pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke 撤销用户创建的令牌，令牌不存在或已撤销时返回 sql.ErrNoRows
+func (s *Store) Revoke(id, createdBy int) error {
+	result, err := s.db.Exec(
+		"UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND created_by = $2 AND revoked_at IS NULL",
+		id, createdBy,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// hash 返回令牌的 SHA-256
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// deliveryTimeout 单次投递的超时时间（包括连接和读取响应）
+	deliveryTimeout = 10 * time.Second
+	// maxURLLength 订阅地址的最大长度
+	maxURLLength = 2048
+)
+
+// errPrivateAddress 订阅地址解析到了内网、回环等非公网地址
+var errPrivateAddress = errors.New("destination address is not public")
+
+// sharedAddressSpace 运营商级 NAT 地址段（100.64.0.0/10），netip 不把它算作私有地址
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// ValidateURL 检查订阅地址，返回的错误信息可以直接返回给调用方
+// 只允许 https（AllowInsecure 时也允许 http），不能带用户名密码；域名解析到的地址在连接时检查
+func (d *Dispatcher) ValidateURL(raw string) error {
+	if raw == "" || len(raw) > maxURLLength {
+		return errors.New("URL must be 1-2048 characters")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Hostname() == "" {
+		return errors.New("invalid URL")
+	}
+	if u.Scheme != "https" && !(d.opts.AllowInsecure && u.Scheme == "http") {
+		return errors.New("URL must use https")
+	}
+	if u.User != nil {
+		return errors.New("URL must not contain credentials")
+	}
+	if !d.opts.AllowInsecure {
+		host := strings.ToLower(u.Hostname())
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return errors.New("URL must point to a public address")
+		}
+		if ip, err := netip.ParseAddr(host); err == nil && !publicAddr(ip) {
+			return errors.New("URL must point to a public address")
+		}
+	}
+	return nil
+}
+
+// newHTTPClient 创建投递用的 HTTP 客户端
+// 不跟随重定向（3xx 视为失败）；allowPrivate 为 false 时在连接前检查实际连接的地址，
+// 防止订阅地址（或其域名解析结果）指向服务器所在的内网
+func newHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 经过代理时实际连接的是代理，地址检查会失效
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   deliveryTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddr 判断是否是公网单播地址
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,154 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"go-chat/internal/services/hub"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 投递请求的请求头
+const (
+	HeaderEvent     = "X-GoChat-Event"     // 事件类型
+	HeaderDelivery  = "X-GoChat-Delivery"  // 投递 ID，重试时不变，订阅方可据此去重
+	HeaderTimestamp = "X-GoChat-Timestamp" // 发送时的 Unix 时间戳（秒），参与签名
+	HeaderSignature = "X-GoChat-Signature" // sha256=<十六进制 HMAC>
+)
+
+// Types 支持订阅的事件类型
+var Types = []string{
+	models.EventMessageCreated,
+	models.EventMemberJoined,
+	models.EventMemberRemoved,
+	models.EventRoomUpdated,
+}
+
+// ValidType 判断是否是支持订阅的事件类型
+func ValidType(eventType string) bool {
+	for _, t := range Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event 投递给订阅方的请求体
+type Event struct {
+	ID        string      `json:"id"` // 同一事件投递给多个订阅时 ID 相同
+	Type      string      `json:"type"`
+	RoomID    int         `json:"room_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Options 投递选项
+type Options struct {
+	// AllowInsecure 允许订阅 http:// 地址并投递到内网、回环地址，仅用于本地开发
+	AllowInsecure bool
+}
+
+// Dispatcher 把房间事件写入 event_deliveries 队列，并在后台签名后投递给订阅方
+// 队列保存在数据库中，多个实例可以同时运行投递，进程重启后未完成的投递会继续
+type Dispatcher struct {
+	db     *sql.DB
+	client *http.Client
+	opts   Options
+}
+
+// NewDispatcher 创建 Dispatcher
+func NewDispatcher(db *sql.DB, opts Options) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		client: newHTTPClient(opts.AllowInsecure),
+		opts:   opts,
+	}
+}
+
+// Emit 在事务 e 中为房间内订阅了该事件的每个订阅写入一条待投递记录，实现 hub.EventSink
+// 订阅的创建者已离开房间或失去管理订阅的权限时不再投递，避免其继续收到房间内容
+func (d *Dispatcher) Emit(e hub.Execer, roomID int, eventType string, data interface{}) error {
+	id, err := newEventID()
+	if err != nil {
+		return fmt.Errorf("generate event ID: %w", err)
+	}
+
+	payload, err := json.Marshal(Event{
+		ID:        id,
+		Type:      eventType,
+		RoomID:    roomID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal %s event: %w", eventType, err)
+	}
+
+	var roles []string
+	for _, role := range permissions.RolesWith(permissions.ManageEventSubs) {
+		roles = append(roles, string(role))
+	}
+
+	if _, err := e.Exec(`
+		INSERT INTO event_deliveries (subscription_id, event_id, event, payload)
+		SELECT s.id, $3, $2, $4::jsonb
+		FROM room_event_subscriptions s
+		INNER JOIN room_members rm ON rm.room_id = s.room_id AND rm.user_id = s.created_by
+		WHERE s.room_id = $1 AND $2 = ANY(s.events) AND rm.role = ANY($5)
+	`, roomID, eventType, id, payload, pq.Array(roles)); err != nil {
+		return fmt.Errorf("enqueue %s event for room %d: %w", eventType, roomID, err)
+	}
+	return nil
+}
+
+// Requeue 把死信重新放回队列立即投递，重试次数从头计算；投递不存在或不是死信时返回 false
+func (d *Dispatcher) Requeue(subscriptionID int, deliveryID int64) (bool, error) {
+	result, err := d.db.Exec(`
+		UPDATE event_deliveries
+		SET status = $3, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, locked_until = NULL
+		WHERE id = $1 AND subscription_id = $2 AND status = $4
+	`, deliveryID, subscriptionID, models.DeliveryPending, models.DeliveryDead)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// NewSecret 生成订阅的签名密钥
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Sign 计算请求签名：HMAC-SHA256(secret, timestamp + "." + body)，十六进制编码并加上 "sha256=" 前缀
+// 订阅方应以同样方式计算后用常量时间比较，并拒绝时间戳过旧的请求以防重放
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID 生成随机的事件 ID
+func newEventID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	const (
+		secret    = "whsec_test"
+		timestamp = "1700000000"
+	)
+	body := []byte(`{"id":"abc"}`)
+
+	// 固定的测试向量，签名算法变化会导致已有订阅方校验失败
+	const want = "sha256=38d2090fd7375d8bb1f1e9059a9956d24e8a05fd20a5adb008c5f18944e39fd1"
+	if got := Sign(secret, timestamp, body); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+
+	// 订阅方按文档的方式计算应得到相同的签名
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if got := Sign(secret, timestamp, body); got != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("Sign does not match HMAC-SHA256(secret, timestamp.body)")
+	}
+
+	// 时间戳、请求体和密钥都参与签名
+	for name, other := range map[string]string{
+		"timestamp": Sign(secret, "1700000001", body),
+		"body":      Sign(secret, timestamp, []byte(`{"id":"abd"}`)),
+		"secret":    Sign("whsec_other", timestamp, body),
+	} {
+		if other == want {
+			t.Errorf("changing the %s does not change the signature", name)
+		}
+	}
+}
+
+func TestValidType(t *testing.T) {
+	for _, eventType := range Types {
+		if !ValidType(eventType) {
+			t.Errorf("ValidType(%q) = false", eventType)
+		}
+	}
+	for _, eventType := range []string{"", "message", "message.deleted", "MESSAGE.CREATED"} {
+		if ValidType(eventType) {
+			t.Errorf("ValidType(%q) = true", eventType)
+		}
+	}
+}
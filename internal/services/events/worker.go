@@ -0,0 +1,274 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-chat/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize 每次领取的投递数，同一批并发发送
+	batchSize = 20
+	// leaseDuration 领取后的租约，必须长于单次投递的超时时间
+	leaseDuration = time.Minute
+	// maxAttempts 最多尝试次数，之后进入死信
+	maxAttempts = 8
+	// baseBackoff / maxBackoff 失败后按 30 秒、1 分钟、2 分钟……指数退避，最长 1 小时
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+	// maxErrorLength 日志中保存的错误信息的最大长度
+	maxErrorLength = 500
+	// maxResponseSize 读取并丢弃的响应体上限，读完响应体才能复用连接
+	maxResponseSize = 64 << 10
+)
+
+const (
+	// cleanupInterval 清理旧投递记录的间隔
+	cleanupInterval = time.Hour
+	// succeededRetention / deadRetention 投递成功和死信记录（及其尝试日志）的保留时间
+	succeededRetention = 7 * 24 * time.Hour
+	deadRetention      = 30 * 24 * time.Hour
+)
+
+// delivery 领取到的一条待投递记录
+type delivery struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int // 包括本次
+	url      string
+	secret   string
+}
+
+// Start 每隔 interval 领取到期的投递并发送，同时定期清理旧记录，返回的函数用于停止并等待当前批次完成
+func (d *Dispatcher) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		cleanup := time.NewTicker(cleanupInterval)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// 一批领满说明还有积压，继续处理直到队列中没有到期的投递
+				for d.processBatch() == batchSize {
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			case <-cleanup.C:
+				d.cleanup()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// processBatch 领取并发送一批投递，返回领取到的数量
+func (d *Dispatcher) processBatch() int {
+	jobs, err := d.claim()
+	if err != nil {
+		log.Printf("Error claiming event deliveries: %v", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job delivery) {
+			defer wg.Done()
+			d.deliver(job)
+		}(job)
+	}
+	wg.Wait()
+	return len(jobs)
+}
+
+// claim 领取到期且没有被其他进程持有的投递，设置租约并计入一次尝试
+// SKIP LOCKED 让多个实例同时领取时互不等待，也不会领到同一条
+func (d *Dispatcher) claim() ([]delivery, error) {
+	rows, err := d.db.Query(`
+		UPDATE event_deliveries ed
+		SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2), attempts = ed.attempts + 1
+		FROM room_event_subscriptions s
+		WHERE s.id = ed.subscription_id AND ed.id IN (
+			SELECT id FROM event_deliveries
+			WHERE status = $3 AND next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ed.id, ed.event, ed.payload, ed.attempts, s.url, s.secret
+	`, batchSize, leaseDuration.Seconds(), models.DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []delivery
+	for rows.Next() {
+		var job delivery
+		if err := rows.Scan(&job.id, &job.event, &job.payload, &job.attempts, &job.url, &job.secret); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// deliver 发送一条投递并记录结果
+func (d *Dispatcher) deliver(job delivery) {
+	start := time.Now()
+	statusCode, err := d.post(job)
+	duration := time.Since(start)
+
+	if err := d.record(job, statusCode, err, duration); err != nil {
+		log.Printf("Error recording event delivery %d: %v", job.id, err)
+	}
+}
+
+// post 签名并发送请求，返回响应状态码（没有收到响应时为 0），非 2xx 响应视为失败
+func (d *Dispatcher) post(job delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(job.payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-chat-webhooks")
+	req.Header.Set(HeaderEvent, job.event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(job.id, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(job.secret, timestamp, job.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record 写入尝试日志并更新投递状态：成功、按退避时间重试，或在用完次数后进入死信
+func (d *Dispatcher) record(job delivery, statusCode int, deliveryErr error, duration time.Duration) error {
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+	var errText *string
+	if deliveryErr != nil {
+		text := deliveryErr.Error()
+		if len(text) > maxErrorLength {
+			text = text[:maxErrorLength]
+		}
+		errText = &text
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT INTO event_delivery_attempts (delivery_id, status_code, error, duration_ms) VALUES ($1, $2, $3, $4)",
+		job.id, code, errText, duration.Milliseconds(),
+	); err != nil {
+		return err
+	}
+
+	switch outcome(job.attempts, deliveryErr) {
+	case models.DeliverySucceeded:
+		_, err = tx.Exec(`
+			UPDATE event_deliveries
+			SET status = $2, delivered_at = CURRENT_TIMESTAMP, locked_until = NULL, last_status_code = $3, last_error = NULL
+			WHERE id = $1
+		`, job.id, models.DeliverySucceeded, code)
+	case models.DeliveryDead:
+		_, err = tx.Exec(`
+			UPDATE event_deliveries
+			SET status = $2, locked_until = NULL, last_status_code = $3, last_error = $4
+			WHERE id = $1
+		`, job.id, models.DeliveryDead, code, errText)
+	default:
+		_, err = tx.Exec(`
+			UPDATE event_deliveries
+			SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2), locked_until = NULL,
+				last_status_code = $3, last_error = $4
+			WHERE id = $1
+		`, job.id, backoff(job.attempts).Seconds(), code, errText)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// outcome 第 attempts 次尝试后投递的状态：成功、用完次数进入死信，或者继续等待重试
+func outcome(attempts int, deliveryErr error) string {
+	switch {
+	case deliveryErr == nil:
+		return models.DeliverySucceeded
+	case attempts >= maxAttempts:
+		return models.DeliveryDead
+	default:
+		return models.DeliveryPending
+	}
+}
+
+// backoff 第 attempts 次失败后到下一次重试的等待时间
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// cleanup 删除超过保留时间的成功和死信记录，尝试日志随之级联删除
+func (d *Dispatcher) cleanup() {
+	result, err := d.db.Exec(`
+		DELETE FROM event_deliveries
+		WHERE (status = $1 AND delivered_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+		   OR (status = $3 AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $4))
+	`, models.DeliverySucceeded, succeededRetention.Seconds(), models.DeliveryDead, deadRetention.Seconds())
+	if err != nil {
+		log.Printf("Error cleaning up event deliveries: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Cleaned up %d event deliveries", n)
+	}
+}
@@ -0,0 +1,228 @@
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-chat/internal/models"
+	"go-chat/internal/permissions"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testDatabaseEnv 指向测试数据库的连接字符串，未设置时跳过需要 Postgres 的测试
+const testDatabaseEnv = "GOCHAT_TEST_DATABASE_URL"
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	failed := errors.New("unexpected response status 500")
+
+	for attempts := 1; attempts <= maxAttempts+1; attempts++ {
+		if got := outcome(attempts, nil); got != models.DeliverySucceeded {
+			t.Errorf("outcome(%d, nil) = %s, want %s", attempts, got, models.DeliverySucceeded)
+		}
+
+		want := models.DeliveryPending
+		if attempts >= maxAttempts {
+			want = models.DeliveryDead
+		}
+		if got := outcome(attempts, failed); got != want {
+			t.Errorf("outcome(%d, err) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// openTestDB 连接测试数据库并运行全部迁移
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../../migrations/*.sql")
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		content, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("read migration: %v", err)
+		}
+		if _, err := db.Exec(string(content)); err != nil {
+			t.Fatalf("run migration %s: %v", migration, err)
+		}
+	}
+	return db
+}
+
+// createTestSubscription 创建用户、房间和订阅了 message.created 的事件订阅，测试结束后删除
+func createTestSubscription(t *testing.T, db *sql.DB, url, secret string) (roomID, subscriptionID int) {
+	t.Helper()
+	name := fmt.Sprintf("events_test_%d", time.Now().UnixNano())
+	var userID int
+	if err := db.QueryRow(
+		"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, '') RETURNING id",
+		name, name+"@example.com",
+	).Scan(&userID); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+	if err := db.QueryRow(
+		"INSERT INTO rooms (name, creator_id) VALUES ($1, $2) RETURNING id", name, userID,
+	).Scan(&roomID); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	if _, err := db.Exec(
+		"INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)",
+		roomID, userID, permissions.RoleCreator,
+	); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := db.QueryRow(`
+		INSERT INTO room_event_subscriptions (room_id, created_by, url, secret, events)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`, roomID, userID, url, secret, pq.Array([]string{models.EventMessageCreated})).Scan(&subscriptionID); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	return roomID, subscriptionID
+}
+
+// TestDispatcherDeadLetter 事件随事务提交或回滚；投递失败用完次数后进入死信，重新入队后从头计数
+func TestDispatcherDeadLetter(t *testing.T) {
+	db := openTestDB(t)
+
+	const secret = "whsec_test"
+	signatures := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- r.Header.Get(HeaderSignature) == Sign(secret, r.Header.Get(HeaderTimestamp), body)
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(db, Options{AllowInsecure: true})
+	roomID, subscriptionID := createTestSubscription(t, db, server.URL, secret)
+
+	countDeliveries := func() int {
+		var n int
+		if err := db.QueryRow(
+			"SELECT COUNT(*) FROM event_deliveries WHERE subscription_id = $1", subscriptionID,
+		).Scan(&n); err != nil {
+			t.Fatalf("count deliveries: %v", err)
+		}
+		return n
+	}
+	emit := func(commit bool) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		defer tx.Rollback()
+		if err := d.Emit(tx, roomID, models.EventMessageCreated, map[string]string{"content": "hi"}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+		if commit {
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("commit: %v", err)
+			}
+		}
+	}
+
+	emit(false)
+	if n := countDeliveries(); n != 0 {
+		t.Fatalf("%d deliveries after rollback, want 0", n)
+	}
+	emit(true)
+	if n := countDeliveries(); n != 1 {
+		t.Fatalf("%d deliveries after commit, want 1", n)
+	}
+
+	// 直接跳到最后一次尝试
+	var deliveryID int64
+	if err := db.QueryRow(
+		"UPDATE event_deliveries SET attempts = $2 WHERE subscription_id = $1 RETURNING id",
+		subscriptionID, maxAttempts-1,
+	).Scan(&deliveryID); err != nil {
+		t.Fatalf("update attempts: %v", err)
+	}
+
+	d.processBatch()
+	select {
+	case ok := <-signatures:
+		if !ok {
+			t.Fatal("delivery signature does not verify")
+		}
+	default:
+		t.Fatal("delivery was not sent")
+	}
+
+	var status string
+	var attempts int
+	var lastStatus sql.NullInt64
+	if err := db.QueryRow(
+		"SELECT status, attempts, last_status_code FROM event_deliveries WHERE id = $1", deliveryID,
+	).Scan(&status, &attempts, &lastStatus); err != nil {
+		t.Fatalf("query delivery: %v", err)
+	}
+	if status != models.DeliveryDead || attempts != maxAttempts || lastStatus.Int64 != http.StatusInternalServerError {
+		t.Fatalf("delivery is %s after %d attempts (last status %v), want %s after %d",
+			status, attempts, lastStatus, models.DeliveryDead, maxAttempts)
+	}
+
+	var logged int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM event_delivery_attempts WHERE delivery_id = $1 AND status_code = 500", deliveryID,
+	).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("%d attempts logged, err %v; want 1", logged, err)
+	}
+
+	ok, err := d.Requeue(subscriptionID, deliveryID)
+	if err != nil || !ok {
+		t.Fatalf("Requeue = %v, %v", ok, err)
+	}
+	if err := db.QueryRow(
+		"SELECT status, attempts FROM event_deliveries WHERE id = $1", deliveryID,
+	).Scan(&status, &attempts); err != nil {
+		t.Fatalf("query delivery: %v", err)
+	}
+	if status != models.DeliveryPending || attempts != 0 {
+		t.Fatalf("after requeue: %s with %d attempts, want %s with 0", status, attempts, models.DeliveryPending)
+	}
+}
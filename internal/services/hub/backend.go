@@ -0,0 +1,40 @@
+package hub
+
+// Backend 广播后端，负责在多个 go-chat 实例之间转发房间消息
+//
+// Hub 总是先把消息投递给本实例的客户端，再通过 Backend 发布给其他实例；
+// 其他实例收到后只投递给自己的客户端，不会再次发布。
+// roomID 为 0 的消息是 Hub 之间的控制指令（如断开连接、定向发送），不会投递给客户端。
+type Backend interface {
+	// Publish 将房间消息发布给其他实例
+	Publish(roomID int, message []byte) error
+
+	// Subscribe 开始接收其他实例发布的消息，deliver 会在后端自己的协程中被调用
+	Subscribe(deliver func(roomID int, message []byte)) error
+
+	// Close 停止接收并释放资源
+	Close() error
+}
+
+// MemoryBackend 单实例内存后端，不做任何跨实例转发（默认后端）
+type MemoryBackend struct{}
+
+// NewMemoryBackend 创建内存后端
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Publish 单实例下无需转发
+func (b *MemoryBackend) Publish(roomID int, message []byte) error {
+	return nil
+}
+
+// Subscribe 单实例下没有其他实例的消息
+func (b *MemoryBackend) Subscribe(deliver func(roomID int, message []byte)) error {
+	return nil
+}
+
+// Close 关闭内存后端
+func (b *MemoryBackend) Close() error {
+	return nil
+}
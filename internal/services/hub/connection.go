@@ -2,8 +2,11 @@ package hub
 
 import (
 	"encoding/json"
+	"errors"
 	"go-chat/internal/models"
+	"go-chat/internal/permissions"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -23,6 +26,23 @@ const (
 	maxMessageSize = 512
 )
 
+var (
+	errSendBufferFull = errors.New("send buffer full")
+	errClientGone     = errors.New("client is not registered")
+)
+
+// writeMessageTypes 会修改房间内容的客户端消息类型，归档的房间拒绝这些操作
+var writeMessageTypes = map[string]bool{
+	"message":         true,
+	"reply":           true,
+	"message_edit":    true,
+	"message_delete":  true,
+	"reaction_add":    true,
+	"reaction_remove": true,
+	"message_pin":     true,
+	"message_unpin":   true,
+}
+
 // Connection WebSocket 连接包装
 type Connection struct {
 	ws *websocket.Conn
@@ -33,8 +53,41 @@ func NewConnection(ws *websocket.Conn) *Connection {
 	return &Connection{ws: ws}
 }
 
+// MessageStore 消息持久化接口，由 handlers 包实现
+// 返回的错误信息会直接发送给客户端，实现方不应在其中暴露内部细节
+type MessageStore interface {
+	// SaveMessage 保存新消息，成功后回填消息 ID；message.created 事件与消息在同一事务中写入
+	// 对于线程回复，ParentID 可能被改写为线程的根消息
+	SaveMessage(msg *models.Message) error
+
+	// GetMessage 获取一条未删除的消息
+	GetMessage(roomID, messageID int) (*models.Message, error)
+
+	// EditMessage 以 userID 的身份修改消息内容，返回修改后的消息
+	EditMessage(roomID, messageID, userID int, content string) (*models.Message, error)
+
+	// DeleteMessage 以 userID 的身份删除消息，返回被删除消息的 ID 和 ParentID
+	DeleteMessage(roomID, messageID, userID int) (*models.Message, error)
+
+	// AddReaction / RemoveReaction 添加或取消表情回应，返回消息更新后的全部回应
+	AddReaction(roomID, messageID, userID int, emoji string) ([]models.Reaction, error)
+	RemoveReaction(roomID, messageID, userID int, emoji string) ([]models.Reaction, error)
+
+	// IsMember 判断用户当前是否仍是房间成员
+	IsMember(roomID, userID int) (bool, error)
+
+	// IsRoomArchived 判断房间是否已归档（只读）
+	IsRoomArchived(roomID int) (bool, error)
+
+	// MemberRole 获取用户在房间中的角色，不是房间成员时返回错误
+	MemberRole(roomID, userID int) (permissions.Role, error)
+
+	// PinMessage 置顶或取消置顶消息，返回更新后的消息；权限由调用方检查
+	PinMessage(roomID, messageID, userID int, pinned bool) (*models.Message, error)
+}
+
 // ReadPump 从 WebSocket 读取消息
-func (c *Client) ReadPump(saveMessage func(*models.Message) error) {
+func (c *Client) ReadPump(store MessageStore) {
 	defer func() {
 		c.Hub.Unregister(c)
 		c.Conn.ws.Close()
@@ -56,9 +109,46 @@ func (c *Client) ReadPump(saveMessage func(*models.Message) error) {
 			break
 		}
 
+		if c.ReadOnly && writeMessageTypes[wsMsg.Type] {
+			c.SendMessage(models.WebSocketMessage{Type: "error", Error: "This token does not have the write scope"})
+			continue
+		}
+
+		// 连接建立后成员可能已被移除，写操作前重新确认成员身份
+		if writeMessageTypes[wsMsg.Type] {
+			member, err := store.IsMember(c.RoomID, c.UserID)
+			if err != nil {
+				log.Printf("Error checking membership of user %d in room %d: %v", c.UserID, c.RoomID, err)
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: "Internal server error"})
+				continue
+			}
+			if !member {
+				c.Hub.DisconnectUser(c.RoomID, c.UserID, models.WebSocketMessage{
+					Type:   "removed",
+					RoomID: c.RoomID,
+					UserID: c.UserID,
+				}, CloseRemoved, "removed")
+				continue
+			}
+		}
+
+		// 归档的房间只读
+		if writeMessageTypes[wsMsg.Type] {
+			archived, err := store.IsRoomArchived(c.RoomID)
+			if err != nil {
+				log.Printf("Error checking room %d archive state: %v", c.RoomID, err)
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: "Internal server error"})
+				continue
+			}
+			if archived {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: "This room is archived"})
+				continue
+			}
+		}
+
 		// 处理不同类型的消息
 		switch wsMsg.Type {
-		case "message":
+		case "message", "reply":
 			// 创建消息对象
 			msg := &models.Message{
 				RoomID:    c.RoomID,
@@ -66,14 +156,31 @@ func (c *Client) ReadPump(saveMessage func(*models.Message) error) {
 				Username:  c.Username,
 				Content:   wsMsg.Content,
 				CreatedAt: time.Now(),
+				// 附件需先通过上传接口上传，这里只传附件 ID
+				AttachmentIDs: wsMsg.AttachmentIDs,
+			}
+
+			// 纯附件消息允许不带文字
+			if strings.TrimSpace(msg.Content) == "" && len(msg.AttachmentIDs) == 0 {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: "Message content is required"})
+				continue
+			}
+
+			// reply 是对某条消息的线程回复
+			if wsMsg.Type == "reply" {
+				if wsMsg.ParentID <= 0 {
+					c.SendMessage(models.WebSocketMessage{Type: "error", Error: "parent_id is required"})
+					continue
+				}
+				msg.ParentID = wsMsg.ParentID
 			}
 
 			// 保存消息到数据库
-			if err := saveMessage(msg); err != nil {
+			if err := store.SaveMessage(msg); err != nil {
 				log.Printf("Error saving message: %v", err)
 				errorMsg := models.WebSocketMessage{
 					Type:  "error",
-					Error: "Failed to save message",
+					Error: err.Error(),
 				}
 				c.SendMessage(errorMsg)
 				continue
@@ -81,11 +188,16 @@ func (c *Client) ReadPump(saveMessage func(*models.Message) error) {
 
 			// 广播消息到房间的所有客户端
 			broadcastMsg := models.WebSocketMessage{
-				Type:    "message",
+				Type:    wsMsg.Type,
 				RoomID:  c.RoomID,
 				Message: msg,
 			}
 
+			// 回复附带更新后的父消息，便于客户端刷新回复数
+			if msg.ParentID != 0 {
+				broadcastMsg.Parent, _ = store.GetMessage(c.RoomID, msg.ParentID)
+			}
+
 			messageBytes, err := json.Marshal(broadcastMsg)
 			if err != nil {
 				log.Printf("Error marshaling message: %v", err)
@@ -93,6 +205,83 @@ func (c *Client) ReadPump(saveMessage func(*models.Message) error) {
 			}
 
 			c.Hub.Broadcast(c.RoomID, messageBytes, nil)
+
+		case "message_edit":
+			msg, err := store.EditMessage(c.RoomID, wsMsg.MessageID, c.UserID, wsMsg.Content)
+			if err != nil {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+
+			c.Hub.BroadcastToRoom(c.RoomID, models.WebSocketMessage{
+				Type:    "message_edit",
+				RoomID:  c.RoomID,
+				Message: msg,
+			}, nil)
+
+		case "message_delete":
+			deleted, err := store.DeleteMessage(c.RoomID, wsMsg.MessageID, c.UserID)
+			if err != nil {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+
+			deleteMsg := models.WebSocketMessage{
+				Type:      "message_delete",
+				RoomID:    c.RoomID,
+				MessageID: wsMsg.MessageID,
+			}
+			if deleted.ParentID != 0 {
+				deleteMsg.Parent, _ = store.GetMessage(c.RoomID, deleted.ParentID)
+			}
+
+			c.Hub.BroadcastToRoom(c.RoomID, deleteMsg, nil)
+
+		case "reaction_add", "reaction_remove":
+			var reactions []models.Reaction
+			var err error
+			if wsMsg.Type == "reaction_add" {
+				reactions, err = store.AddReaction(c.RoomID, wsMsg.MessageID, c.UserID, wsMsg.Emoji)
+			} else {
+				reactions, err = store.RemoveReaction(c.RoomID, wsMsg.MessageID, c.UserID, wsMsg.Emoji)
+			}
+			if err != nil {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+
+			c.Hub.BroadcastToRoom(c.RoomID, models.WebSocketMessage{
+				Type:      wsMsg.Type,
+				RoomID:    c.RoomID,
+				MessageID: wsMsg.MessageID,
+				UserID:    c.UserID,
+				Username:  c.Username,
+				Emoji:     wsMsg.Emoji,
+				Reactions: reactions,
+			}, nil)
+
+		case "message_pin", "message_unpin":
+			role, err := store.MemberRole(c.RoomID, c.UserID)
+			if err != nil {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: "You are not a member of this room"})
+				continue
+			}
+			if !permissions.Can(role, permissions.Pin) {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: permissions.Denied(permissions.Pin)})
+				continue
+			}
+
+			msg, err := store.PinMessage(c.RoomID, wsMsg.MessageID, c.UserID, wsMsg.Type == "message_pin")
+			if err != nil {
+				c.SendMessage(models.WebSocketMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+
+			c.Hub.BroadcastToRoom(c.RoomID, models.WebSocketMessage{
+				Type:    wsMsg.Type,
+				RoomID:  c.RoomID,
+				Message: msg,
+			}, nil)
 		}
 	}
 }
@@ -110,8 +299,12 @@ func (c *Client) WritePump() {
 		case message, ok := <-c.Send:
 			c.Conn.ws.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				// Hub 关闭了通道
-				c.Conn.ws.WriteMessage(websocket.CloseMessage, []byte{})
+				// Hub 关闭了通道，如果指定了关闭码则告知客户端断开原因
+				closeMessage := []byte{}
+				if c.closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				}
+				c.Conn.ws.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
@@ -143,18 +336,5 @@ func (c *Client) WritePump() {
 
 // SendMessage 发送消息给客户端
 func (c *Client) SendMessage(msg models.WebSocketMessage) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	select {
-	case c.Send <- data:
-	default:
-		// 通道已满，关闭连接
-		close(c.Send)
-		return err
-	}
-
-	return nil
+	return c.Hub.sendToClient(c, msg)
 }
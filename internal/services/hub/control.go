@@ -0,0 +1,84 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-chat/internal/models"
+	"log"
+)
+
+// controlRoomID 在实例之间转发控制指令时使用的保留房间 ID，真实房间 ID 从 1 开始
+const controlRoomID = 0
+
+// controlCommand 实例之间转发的控制指令，每条指令只设置其中一个字段
+type controlCommand struct {
+	Disconnect *disconnectCommand `json:"disconnect,omitempty"`
+	Send       *sendCommand       `json:"send,omitempty"`
+	Presence   *presenceCommand   `json:"presence,omitempty"`
+}
+
+// sendCommand 向房间内指定用户的连接发送消息的指令
+type sendCommand struct {
+	RoomID  int             `json:"room_id"`
+	UserIDs []int           `json:"user_ids"`
+	Message json.RawMessage `json:"message"`
+}
+
+// publishControl 把控制指令发布给其他实例
+func (h *Hub) publishControl(cmd *controlCommand) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		log.Printf("Error marshaling control command: %v", err)
+		return
+	}
+	if err := h.backend.Publish(controlRoomID, payload); err != nil {
+		log.Printf("Error publishing control command: %v", err)
+	}
+}
+
+// receiveControl 处理其他实例发布的控制指令
+func (h *Hub) receiveControl(payload []byte) {
+	var cmd controlCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		log.Printf("Error decoding control command: %v", err)
+		return
+	}
+
+	switch {
+	case cmd.Disconnect != nil:
+		h.applyDisconnect(cmd.Disconnect)
+	case cmd.Send != nil:
+		h.applySend(cmd.Send)
+	case cmd.Presence != nil:
+		h.applyPresence(cmd.Presence)
+	}
+}
+
+// SendToUsers 向房间内指定用户的所有连接发送消息（包括其他实例上的连接）
+// 用于只有部分成员应当看到的通知，例如发给审批人的加入申请
+func (h *Hub) SendToUsers(roomID int, userIDs []int, message models.WebSocketMessage) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	cmd := &sendCommand{RoomID: roomID, UserIDs: userIDs, Message: data}
+	h.applySend(cmd)
+	h.publishControl(&controlCommand{Send: cmd})
+}
+
+// applySend 把消息投递给本实例上匹配的连接
+func (h *Hub) applySend(cmd *sendCommand) {
+	users := make(map[int]bool, len(cmd.UserIDs))
+	for _, id := range cmd.UserIDs {
+		users[id] = true
+	}
+
+	h.deliverBytesTo(cmd.RoomID, []byte(cmd.Message), func(client *Client) bool {
+		return users[client.UserID]
+	})
+}
@@ -0,0 +1,167 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-chat/internal/models"
+	"log"
+)
+
+// 应用自定义的 WebSocket 关闭码（4000-4999），客户端据此决定是否重连
+const (
+	// CloseLoggedOut 用户已退出登录（session 被撤销）
+	CloseLoggedOut = 4001
+	// CloseRemoved 用户已被移出房间或主动离开房间
+	CloseRemoved = 4003
+	// CloseRoomDeleted 房间已被删除
+	CloseRoomDeleted = 4004
+)
+
+// disconnectCommand 断开匹配客户端的指令，会通过广播后端转发给其他实例
+type disconnectCommand struct {
+	// RoomID 为 0 时匹配所有房间
+	RoomID int `json:"room_id"`
+	// UserID 非 0 时只断开该用户的连接
+	UserID int `json:"user_id,omitempty"`
+	// SessionID 非空时只断开使用该 session 建立的连接
+	SessionID string `json:"session_id,omitempty"`
+	// ExceptSessionID 非空时保留使用该 session 建立的连接
+	ExceptSessionID string `json:"except_session_id,omitempty"`
+	Code            int    `json:"code"`
+	Reason          string `json:"reason"`
+	// Message 断开前发送给客户端的最后一条消息
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// matches 判断客户端是否需要被断开
+func (cmd *disconnectCommand) matches(client *Client) bool {
+	return (cmd.RoomID == 0 || client.RoomID == cmd.RoomID) &&
+		(cmd.UserID == 0 || client.UserID == cmd.UserID) &&
+		(cmd.SessionID == "" || client.SessionID == cmd.SessionID) &&
+		(cmd.ExceptSessionID == "" || client.SessionID != cmd.ExceptSessionID)
+}
+
+// DisconnectRoom 通知并断开房间内所有客户端（包括其他实例上的客户端）
+// 客户端会先收到 message，随后连接以 code 关闭
+func (h *Hub) DisconnectRoom(roomID int, message models.WebSocketMessage, code int, reason string) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling disconnect message: %v", err)
+		return
+	}
+
+	h.disconnect(&disconnectCommand{
+		RoomID:  roomID,
+		Code:    code,
+		Reason:  reason,
+		Message: data,
+	})
+}
+
+// DisconnectUser 通知并断开用户在房间内的所有连接（包括其他实例上的连接）
+func (h *Hub) DisconnectUser(roomID, userID int, message models.WebSocketMessage, code int, reason string) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling disconnect message: %v", err)
+		return
+	}
+
+	h.disconnect(&disconnectCommand{
+		RoomID:  roomID,
+		UserID:  userID,
+		Code:    code,
+		Reason:  reason,
+		Message: data,
+	})
+}
+
+// DisconnectUserEverywhere 通知并断开用户在所有房间的连接（包括其他实例上的连接）
+func (h *Hub) DisconnectUserEverywhere(userID int, message models.WebSocketMessage, code int, reason string) {
+	h.DisconnectUser(0, userID, message, code, reason)
+}
+
+// DisconnectSession 通知并断开使用某个 session 建立的所有连接（包括其他实例上的连接）
+func (h *Hub) DisconnectSession(sessionID string, message models.WebSocketMessage, code int, reason string) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling disconnect message: %v", err)
+		return
+	}
+
+	h.disconnect(&disconnectCommand{
+		SessionID: sessionID,
+		Code:      code,
+		Reason:    reason,
+		Message:   data,
+	})
+}
+
+// DisconnectOtherSessions 通知并断开用户除 keepSessionID 以外的 session 建立的连接（包括其他实例上的连接）
+func (h *Hub) DisconnectOtherSessions(userID int, keepSessionID string, message models.WebSocketMessage, code int, reason string) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling disconnect message: %v", err)
+		return
+	}
+
+	h.disconnect(&disconnectCommand{
+		UserID:          userID,
+		ExceptSessionID: keepSessionID,
+		Code:            code,
+		Reason:          reason,
+		Message:         data,
+	})
+}
+
+// disconnect 在本实例执行断开指令，并发布给其他实例
+func (h *Hub) disconnect(cmd *disconnectCommand) {
+	h.applyDisconnect(cmd)
+
+	h.publishControl(&controlCommand{Disconnect: cmd})
+}
+
+// applyDisconnect 断开本实例上匹配的客户端
+func (h *Hub) applyDisconnect(cmd *disconnectCommand) {
+	var left []*Client
+
+	h.mu.Lock()
+	for _, client := range h.candidatesLocked(cmd.RoomID) {
+		if !cmd.matches(client) {
+			continue
+		}
+
+		// 最后一条消息直接写入发送通道，跳过补发暂存；缓冲区已满时只关闭连接
+		if len(cmd.Message) > 0 {
+			select {
+			case client.Send <- []byte(cmd.Message):
+			default:
+			}
+		}
+
+		// 关闭码在关闭发送通道之前设置，WritePump 读到通道关闭后再读取
+		client.closeCode = cmd.Code
+		client.closeReason = cmd.Reason
+		if _, lastConn := h.removeClientLocked(client); lastConn {
+			left = append(left, client)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range left {
+		h.notifyLeave(client)
+	}
+}
+
+// candidatesLocked 返回房间内的全部客户端，roomID 为 0 时返回所有房间的客户端，调用方需持有锁
+// 返回副本，调用方可以在遍历时移除客户端
+func (h *Hub) candidatesLocked(roomID int) []*Client {
+	var clients []*Client
+	for id, room := range h.rooms {
+		if roomID != 0 && id != roomID {
+			continue
+		}
+		for client := range room {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
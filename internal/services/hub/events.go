@@ -0,0 +1,28 @@
+package hub
+
+import "database/sql"
+
+// Execer 可以执行写操作的对象（*sql.DB 或 *sql.Tx）
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// EventSink 接收房间事件并转发给外部系统（事件订阅），由 events 包实现
+// Emit 在产生事件的数据库事务中调用：事件与数据变更一起提交或回滚，提交后不会丢失
+type EventSink interface {
+	Emit(e Execer, roomID int, eventType string, data interface{}) error
+}
+
+// SetEventSink 设置房间事件的接收方，应在 Run 之前调用；未设置时事件被丢弃
+func (h *Hub) SetEventSink(sink EventSink) {
+	h.events = sink
+}
+
+// Emit 在事务 e 中发出一个房间事件，eventType 为 models.Event* 常量
+// 返回错误时调用方应回滚事务，避免数据已变更而事件丢失
+func (h *Hub) Emit(e Execer, roomID int, eventType string, data interface{}) error {
+	if h.events == nil {
+		return nil
+	}
+	return h.events.Emit(e, roomID, eventType, data)
+}
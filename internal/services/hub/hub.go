@@ -1,9 +1,12 @@
 package hub
 
 import (
+	"encoding/json"
 	"go-chat/internal/models"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // Client 代表一个 WebSocket 客户端
@@ -14,6 +17,31 @@ type Client struct {
 	UserID   int
 	Username string
 	Send     chan []byte
+
+	// SessionID 建立连接时使用的凭据（登录 session 或 API 令牌），凭据被撤销时据此断开连接
+	SessionID string
+
+	// ReadOnly 为 true 表示连接使用的 API 令牌没有 write 权限，只能接收消息
+	ReadOnly bool
+
+	// Resuming 为 true 表示客户端是断线重连，需要先补发 LastMessageID 之后的消息
+	Resuming      bool
+	LastMessageID int
+
+	// resuming 和 held 由 Hub 的锁保护：补发完成前实时消息暂存在 held 中
+	resuming bool
+	held     [][]byte
+
+	// closeCode 和 closeReason 在 Hub 关闭发送通道前设置，WritePump 用它们发送关闭帧
+	closeCode   int
+	closeReason string
+}
+
+// presenceEntry 记录某个用户在房间内的在线状态
+type presenceEntry struct {
+	Username string
+	// Conns 该用户在房间内的连接数（同一用户可能打开多个标签页）
+	Conns int
 }
 
 // Hub 管理所有活跃的客户端和房间
@@ -22,19 +50,50 @@ type Hub struct {
 	// key: roomID, value: map of clients
 	rooms map[int]map[*Client]bool
 
+	// presence 存储每个房间在本实例上的在线用户名单
+	// key: roomID, value: map of userID -> presenceEntry
+	presence map[int]map[int]*presenceEntry
+
+	// node 本实例的随机标识，用于区分在线状态指令来自哪个实例
+	node string
+
+	// remote 其他实例通过广播后端同步过来的在线名单
+	// key: node, value: 该实例的在线名单
+	remote map[string]*nodePresence
+
+	// presenceOut 等待发布给其他实例的在线状态指令，由 syncPresence 按顺序发布
+	presenceOut chan *presenceCommand
+
+	// presenceStop / presenceStopped 用于在 Close 时停止 syncPresence
+	presenceStop    chan struct{}
+	presenceStopped chan struct{}
+	presenceStarted atomic.Bool
+
 	// broadcast 广播消息到特定房间
 	broadcast chan *BroadcastMessage
 
 	// register 注册新客户端
-	register chan *Client
+	register chan *registration
 
 	// unregister 注销客户端
 	unregister chan *Client
 
+	// backend 跨实例广播后端
+	backend Backend
+
+	// events 房间事件的接收方，可以为空
+	events EventSink
+
 	// mutex 用于并发安全
 	mu sync.RWMutex
 }
 
+// registration 注册请求，Run 处理完成后关闭 done
+type registration struct {
+	client *Client
+	done   chan struct{}
+}
+
 // BroadcastMessage 广播消息结构
 type BroadcastMessage struct {
 	RoomID  int
@@ -42,93 +101,104 @@ type BroadcastMessage struct {
 	Sender  *Client // 可选，用于排除发送者
 }
 
-// NewHub 创建新的 Hub
+// NewHub 创建使用内存后端的 Hub（单实例）
 func NewHub() *Hub {
+	return NewHubWithBackend(NewMemoryBackend())
+}
+
+// NewHubWithBackend 创建使用指定广播后端的 Hub
+func NewHubWithBackend(backend Backend) *Hub {
+	// crypto/rand 不会返回错误
+	node, _ := newNodeID()
 	return &Hub{
-		rooms:      make(map[int]map[*Client]bool),
-		broadcast:  make(chan *BroadcastMessage, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		rooms:           make(map[int]map[*Client]bool),
+		presence:        make(map[int]map[int]*presenceEntry),
+		node:            node,
+		remote:          make(map[string]*nodePresence),
+		presenceOut:     make(chan *presenceCommand, presenceQueueSize),
+		presenceStop:    make(chan struct{}),
+		presenceStopped: make(chan struct{}),
+		broadcast:       make(chan *BroadcastMessage, 256),
+		register:        make(chan *registration),
+		unregister:      make(chan *Client),
+		backend:         backend,
 	}
 }
 
 // Run 启动 Hub
 func (h *Hub) Run() {
+	// 接收其他实例发布的消息，只投递给本实例的客户端
+	if err := h.backend.Subscribe(h.receiveRemote); err != nil {
+		log.Printf("Error subscribing to broadcast backend: %v", err)
+	}
+	h.startPresenceSync()
+
 	for {
 		select {
-		case client := <-h.register:
+		case reg := <-h.register:
+			client := reg.client
 			h.mu.Lock()
 			if h.rooms[client.RoomID] == nil {
 				h.rooms[client.RoomID] = make(map[*Client]bool)
 			}
 			h.rooms[client.RoomID][client] = true
+			client.resuming = client.Resuming
+			firstConn := h.addPresenceLocked(client)
+			snapshot := h.onlineUsersLocked(client.RoomID)
 			h.mu.Unlock()
 
 			log.Printf("Client registered: User %d (%s) joined room %d",
 				client.UserID, client.Username, client.RoomID)
 
-			// 通知房间其他成员有新用户加入
-			joinMsg := models.WebSocketMessage{
-				Type:     "join",
-				RoomID:   client.RoomID,
-				UserID:   client.UserID,
-				Username: client.Username,
+			// 向新客户端发送当前在线用户快照
+			if err := h.sendToClient(client, models.WebSocketMessage{
+				Type:   "presence",
+				RoomID: client.RoomID,
+				Users:  snapshot,
+			}); err != nil {
+				log.Printf("Error sending presence to user %d: %v", client.UserID, err)
+			}
+
+			// 只有用户在所有实例上的第一个连接才通知房间其他成员有新用户加入
+			if firstConn {
+				h.deliver(client.RoomID, models.WebSocketMessage{
+					Type:     "join",
+					RoomID:   client.RoomID,
+					UserID:   client.UserID,
+					Username: client.Username,
+				}, client)
 			}
-			h.BroadcastToRoom(client.RoomID, joinMsg, nil)
+			close(reg.done)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if clients, ok := h.rooms[client.RoomID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.Send)
-
-					// 如果房间没有客户端了，删除房间
-					if len(clients) == 0 {
-						delete(h.rooms, client.RoomID)
-					}
-				}
-			}
+			removed, lastConn := h.removeClientLocked(client)
 			h.mu.Unlock()
 
+			if !removed {
+				continue
+			}
+
 			log.Printf("Client unregistered: User %d (%s) left room %d",
 				client.UserID, client.Username, client.RoomID)
 
-			// 通知房间其他成员有用户离开
-			leaveMsg := models.WebSocketMessage{
-				Type:     "leave",
-				RoomID:   client.RoomID,
-				UserID:   client.UserID,
-				Username: client.Username,
+			// 用户在所有实例上的最后一个连接断开时，通知房间其他成员有用户离开
+			if lastConn {
+				h.notifyLeave(client)
 			}
-			h.BroadcastToRoom(client.RoomID, leaveMsg, nil)
 
 		case msg := <-h.broadcast:
-			h.mu.RLock()
-			if clients, ok := h.rooms[msg.RoomID]; ok {
-				for client := range clients {
-					// 如果指定了发送者，则不发送给发送者自己
-					if msg.Sender != nil && client == msg.Sender {
-						continue
-					}
-
-					select {
-					case client.Send <- msg.Message:
-					default:
-						// 发送失败，关闭连接
-						close(client.Send)
-						delete(clients, client)
-					}
-				}
-			}
-			h.mu.RUnlock()
+			h.deliverBytes(msg.RoomID, msg.Message, msg.Sender)
 		}
 	}
 }
 
-// Register 注册客户端到 Hub
+// Register 注册客户端到 Hub，返回时客户端已加入房间并收到在线状态快照
+// 调用方可以在返回后立即调用 Resume 补发消息
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	reg := &registration{client: client, done: make(chan struct{})}
+	h.register <- reg
+	<-reg.done
 }
 
 // Unregister 从 Hub 注销客户端
@@ -137,18 +207,244 @@ func (h *Hub) Unregister(client *Client) {
 }
 
 // Broadcast 向房间广播消息
+// 消息先投递给本实例的客户端，再通过后端发布给其他实例
 func (h *Hub) Broadcast(roomID int, message []byte, sender *Client) {
 	h.broadcast <- &BroadcastMessage{
 		RoomID:  roomID,
 		Message: message,
 		Sender:  sender,
 	}
+
+	if err := h.backend.Publish(roomID, message); err != nil {
+		log.Printf("Error publishing message to room %d: %v", roomID, err)
+	}
 }
 
-// BroadcastToRoom 向指定房间广播消息
+// receiveRemote 处理其他实例发布的消息
+func (h *Hub) receiveRemote(roomID int, message []byte) {
+	if roomID == controlRoomID {
+		h.receiveControl(message)
+		return
+	}
+
+	h.broadcast <- &BroadcastMessage{
+		RoomID:  roomID,
+		Message: message,
+	}
+}
+
+// Close 通知其他实例本实例的用户已离线，然后关闭广播后端
+func (h *Hub) Close() error {
+	h.stopPresenceSync()
+	return h.backend.Close()
+}
+
+// BroadcastToRoom 向指定房间广播一条 WebSocketMessage
+// 供 Hub 外部（如 HTTP 处理器）调用，消息会经由 broadcast channel 投递
 func (h *Hub) BroadcastToRoom(roomID int, message models.WebSocketMessage, excludeClient *Client) {
-	// 此函数在外部调用时使用
-	// 实际的广播逻辑在 Run() 的 broadcast channel 中处理
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling broadcast message: %v", err)
+		return
+	}
+	h.Broadcast(roomID, data, excludeClient)
+}
+
+// deliver 在 Run 所在的协程内直接向本实例的房间投递消息
+// 不能在 Run 中调用 BroadcastToRoom，否则 broadcast channel 写满时会死锁；
+// join/leave 事件不经广播后端转发，而是由各实例根据同步的在线名单各自产生
+func (h *Hub) deliver(roomID int, message models.WebSocketMessage, excludeClient *Client) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling broadcast message: %v", err)
+		return
+	}
+	h.deliverBytes(roomID, data, excludeClient)
+}
+
+// deliverBytes 将已序列化的消息发送给房间内的客户端
+func (h *Hub) deliverBytes(roomID int, data []byte, excludeClient *Client) {
+	// 如果指定了发送者，则不发送给发送者自己
+	h.deliverBytesTo(roomID, data, func(client *Client) bool {
+		return excludeClient == nil || client != excludeClient
+	})
+}
+
+// deliverBytesTo 将已序列化的消息发送给房间内 match 返回 true 的客户端
+func (h *Hub) deliverBytesTo(roomID int, data []byte, match func(*Client) bool) {
+	var dropped []*Client
+
+	h.mu.Lock()
+	if clients, ok := h.rooms[roomID]; ok {
+		for client := range clients {
+			if !match(client) {
+				continue
+			}
+
+			if client.enqueueLocked(data) {
+				continue
+			}
+
+			// 发送失败，关闭连接
+			if _, lastConn := h.removeClientLocked(client); lastConn {
+				dropped = append(dropped, client)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	// 因发送缓冲区已满而被移除的用户同样需要通知离开
+	for _, client := range dropped {
+		h.notifyLeave(client)
+	}
+}
+
+// sendToClient 向单个客户端发送消息，缓冲区已满时直接丢弃
+func (h *Hub) sendToClient(client *Client, message models.WebSocketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// 发送通道由 Hub 在写锁下关闭，持有读锁检查注册状态可避免向已关闭的通道写入
+	if !h.rooms[client.RoomID][client] {
+		return errClientGone
+	}
+
+	select {
+	case client.Send <- data:
+		return nil
+	default:
+		return errSendBufferFull
+	}
+}
+
+// notifyLeave 通知房间其他成员有用户离开
+func (h *Hub) notifyLeave(client *Client) {
+	h.deliver(client.RoomID, models.WebSocketMessage{
+		Type:     "leave",
+		RoomID:   client.RoomID,
+		UserID:   client.UserID,
+		Username: client.Username,
+	}, nil)
+}
+
+// removeClientLocked 从房间中移除客户端并关闭其发送通道，调用方需持有写锁
+// removed 表示客户端此前是否已注册，lastConn 表示该用户是否已在所有实例上离线
+func (h *Hub) removeClientLocked(client *Client) (removed bool, lastConn bool) {
+	clients, ok := h.rooms[client.RoomID]
+	if !ok {
+		return false, false
+	}
+	if _, exists := clients[client]; !exists {
+		return false, false
+	}
+
+	delete(clients, client)
+	close(client.Send)
+
+	// 如果房间没有客户端了，删除房间
+	if len(clients) == 0 {
+		delete(h.rooms, client.RoomID)
+	}
+
+	return true, h.removePresenceLocked(client)
+}
+
+// addPresenceLocked 记录用户上线并发布给其他实例，返回是否是该用户在所有实例上的第一个连接
+func (h *Hub) addPresenceLocked(client *Client) bool {
+	roster, ok := h.presence[client.RoomID]
+	if !ok {
+		roster = make(map[int]*presenceEntry)
+		h.presence[client.RoomID] = roster
+	}
+
+	entry, ok := roster[client.UserID]
+	if !ok {
+		entry = &presenceEntry{Username: client.Username}
+		roster[client.UserID] = entry
+	}
+	entry.Conns++
+	h.queuePresenceUpdateLocked(client, entry.Conns)
+
+	return entry.Conns == 1 && !h.onlineRemotelyLocked(client.RoomID, client.UserID)
+}
+
+// removePresenceLocked 记录用户断开一个连接并发布给其他实例，返回该用户是否已在所有实例上离线
+func (h *Hub) removePresenceLocked(client *Client) bool {
+	roster, ok := h.presence[client.RoomID]
+	if !ok {
+		return false
+	}
+
+	entry, ok := roster[client.UserID]
+	if !ok {
+		return false
+	}
+
+	entry.Conns--
+	h.queuePresenceUpdateLocked(client, entry.Conns)
+	if entry.Conns > 0 {
+		return false
+	}
+
+	delete(roster, client.UserID)
+	if len(roster) == 0 {
+		delete(h.presence, client.RoomID)
+	}
+	return !h.onlineRemotelyLocked(client.RoomID, client.UserID)
+}
+
+// onlineUsersLocked 返回房间在所有实例上的在线用户列表（按用户名排序），连接数为各实例之和，调用方需持有锁
+func (h *Hub) onlineUsersLocked(roomID int) []models.OnlineUser {
+	merged := make(map[int]*models.OnlineUser, len(h.presence[roomID]))
+	add := func(roster map[int]*presenceEntry) {
+		for userID, entry := range roster {
+			if user, ok := merged[userID]; ok {
+				user.Connections += entry.Conns
+				continue
+			}
+			merged[userID] = &models.OnlineUser{
+				UserID:      userID,
+				Username:    entry.Username,
+				Connections: entry.Conns,
+			}
+		}
+	}
+	add(h.presence[roomID])
+	for _, node := range h.remote {
+		add(node.rooms[roomID])
+	}
+
+	users := make([]models.OnlineUser, 0, len(merged))
+	for _, user := range merged {
+		users = append(users, *user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// OnlineUsers 获取房间的在线用户列表（包括其他实例上的用户）
+func (h *Hub) OnlineUsers(roomID int) []models.OnlineUser {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.onlineUsersLocked(roomID)
+}
+
+// IsOnline 判断用户是否在房间内在线（包括其他实例上的连接）
+func (h *Hub) IsOnline(roomID, userID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.onlineAnywhereLocked(roomID, userID)
+	return ok
 }
 
 // GetRoomClients 获取房间的所有客户端
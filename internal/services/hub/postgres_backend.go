@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// notifyChannel Postgres LISTEN/NOTIFY 使用的通道名
+	notifyChannel = "gochat_broadcast"
+
+	// maxNotifyPayload NOTIFY 负载的上限（Postgres 默认限制为 8000 字节）
+	// 超过上限的消息写入 hub_broadcasts 表，通知中只携带记录 ID
+	maxNotifyPayload = 7900
+
+	// broadcastRetention hub_broadcasts 中记录的保留时间，各实例应在此之前读取完毕
+	broadcastRetention = 5 * time.Minute
+
+	// listenerPingPeriod 监听连接的保活间隔
+	listenerPingPeriod = 90 * time.Second
+)
+
+// notifyEnvelope 在实例之间传递的消息信封，Data 和 Ref 只设置其中一个
+type notifyEnvelope struct {
+	Node   string          `json:"node"`
+	RoomID int             `json:"room_id"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	// Ref 消息过大时在 hub_broadcasts 中的记录 ID
+	Ref int64 `json:"ref,omitempty"`
+}
+
+// PostgresBackend 基于 Postgres LISTEN/NOTIFY 的广播后端
+type PostgresBackend struct {
+	db       *sql.DB
+	listener *pq.Listener
+	nodeID   string
+	done     chan struct{}
+}
+
+// NewPostgresBackend 创建 Postgres 广播后端
+// db 用于发送 NOTIFY，connStr 用于建立独立的 LISTEN 连接
+func NewPostgresBackend(db *sql.DB, connStr string) (*PostgresBackend, error) {
+	nodeID, err := newNodeID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node id: %w", err)
+	}
+
+	listener := pq.NewListener(connStr, 10*time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Postgres listener error: %v", err)
+			}
+		})
+
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", notifyChannel, err)
+	}
+
+	log.Printf("Postgres broadcast backend started (node %s)", nodeID)
+
+	return &PostgresBackend{
+		db:       db,
+		listener: listener,
+		nodeID:   nodeID,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Publish 通过 NOTIFY 将消息发布给所有实例
+// 超过 NOTIFY 上限的消息先写入 hub_broadcasts，再只通知记录 ID
+func (b *PostgresBackend) Publish(roomID int, message []byte) error {
+	payload, err := json.Marshal(notifyEnvelope{
+		Node:   b.nodeID,
+		RoomID: roomID,
+		Data:   message,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		var id int64
+		if err := b.db.QueryRow(
+			"INSERT INTO hub_broadcasts (room_id, data) VALUES ($1, $2) RETURNING id",
+			roomID, message,
+		).Scan(&id); err != nil {
+			return fmt.Errorf("failed to store large broadcast: %w", err)
+		}
+
+		payload, err = json.Marshal(notifyEnvelope{
+			Node:   b.nodeID,
+			RoomID: roomID,
+			Ref:    id,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.db.Exec("SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	return err
+}
+
+// Subscribe 启动监听协程，将其他实例的消息交给 deliver
+func (b *PostgresBackend) Subscribe(deliver func(roomID int, message []byte)) error {
+	go func() {
+		ticker := time.NewTicker(listenerPingPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case n, ok := <-b.listener.Notify:
+				if !ok {
+					return
+				}
+				if n == nil {
+					// 监听连接重连后会收到 nil，期间的通知可能已丢失
+					log.Println("Postgres listener reconnected, notifications may have been missed")
+					continue
+				}
+
+				var env notifyEnvelope
+				if err := json.Unmarshal([]byte(n.Extra), &env); err != nil {
+					log.Printf("Error decoding broadcast notification: %v", err)
+					continue
+				}
+
+				// 本实例发布的消息已经在本地投递过了
+				if env.Node == b.nodeID {
+					continue
+				}
+
+				data := []byte(env.Data)
+				if env.Ref != 0 {
+					var err error
+					if data, err = b.load(env.Ref); err != nil {
+						log.Printf("Error loading broadcast %d: %v", env.Ref, err)
+						continue
+					}
+				}
+
+				deliver(env.RoomID, data)
+
+			case <-ticker.C:
+				if err := b.listener.Ping(); err != nil {
+					log.Printf("Postgres listener ping failed: %v", err)
+				}
+				b.cleanup()
+
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// load 读取写入 hub_broadcasts 的消息
+func (b *PostgresBackend) load(id int64) ([]byte, error) {
+	var data []byte
+	err := b.db.QueryRow("SELECT data FROM hub_broadcasts WHERE id = $1", id).Scan(&data)
+	return data, err
+}
+
+// cleanup 删除超过保留时间的 hub_broadcasts 记录
+func (b *PostgresBackend) cleanup() {
+	if _, err := b.db.Exec(
+		"DELETE FROM hub_broadcasts WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)",
+		broadcastRetention.Seconds(),
+	); err != nil {
+		log.Printf("Error cleaning up hub broadcasts: %v", err)
+	}
+}
+
+// Close 停止监听并关闭监听连接
+func (b *PostgresBackend) Close() error {
+	close(b.done)
+	return b.listener.Close()
+}
+
+// newNodeID 生成当前实例的随机标识
+func newNodeID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
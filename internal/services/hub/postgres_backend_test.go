@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"go-chat/internal/models"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv 指向测试数据库的连接字符串，未设置时跳过需要 Postgres 的测试
+const testDatabaseEnv = "GOCHAT_TEST_DATABASE_URL"
+
+// newTestBackend 创建连接到测试数据库的广播后端
+func newTestBackend(t *testing.T, db *sql.DB, connStr string) *PostgresBackend {
+	t.Helper()
+	backend, err := NewPostgresBackend(db, connStr)
+	if err != nil {
+		t.Fatalf("NewPostgresBackend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+// waitForMessage 等待客户端收到 want，忽略期间的其他消息（如在线状态快照）
+func waitForMessage(t *testing.T, client *Client, want []byte) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-client.Send:
+			if bytes.Equal(got, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("message of %d bytes was not delivered to the other node", len(want))
+		}
+	}
+}
+
+// TestPostgresBackendMultiNode 两个 Hub 共用一个数据库，一个实例广播的消息应当投递到另一个实例的客户端
+func TestPostgresBackendMultiNode(t *testing.T) {
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	migration, err := os.ReadFile("../../../migrations/022_hub_broadcasts.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+	if _, err := db.Exec(string(migration)); err != nil {
+		t.Fatalf("run migration: %v", err)
+	}
+
+	hubA := NewHubWithBackend(newTestBackend(t, db, connStr))
+	hubB := NewHubWithBackend(newTestBackend(t, db, connStr))
+	go hubA.Run()
+	go hubB.Run()
+
+	const roomID = 42
+	client := &Client{Hub: hubB, RoomID: roomID, UserID: 1, Username: "bob", Send: make(chan []byte, 16)}
+	hubB.Register(client)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"small", "hello"},
+		{"oversized", strings.Repeat("x", 2*maxNotifyPayload)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(models.WebSocketMessage{
+				Type:     "message",
+				RoomID:   roomID,
+				UserID:   2,
+				Username: "alice",
+				Content:  tt.content,
+			})
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			hubA.Broadcast(roomID, data, nil)
+			waitForMessage(t, client, data)
+		})
+	}
+
+	// 在线状态经由 Postgres 同步：在 A 上加入的用户出现在 B 的在线名单中，离开后 B 上的客户端收到 leave
+	t.Run("presence", func(t *testing.T) {
+		alice := &Client{Hub: hubA, RoomID: roomID, UserID: 2, Username: "alice", Send: make(chan []byte, 16)}
+		hubA.Register(alice)
+		expectPresence(t, client, "join", 2)
+		if conns := onlineConns(hubB.OnlineUsers(roomID), 2); conns != 1 {
+			t.Fatalf("alice has %d connections on node B, want 1", conns)
+		}
+
+		hubA.Unregister(alice)
+		expectPresence(t, client, "leave", 2)
+		if hubB.IsOnline(roomID, 2) {
+			t.Fatal("alice is still online on node B")
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package hub
+
+import (
+	"go-chat/internal/models"
+	"log"
+	"time"
+)
+
+const (
+	// presenceSyncInterval 每个实例发布完整在线名单的间隔，用于纠正丢失的增量更新
+	presenceSyncInterval = 30 * time.Second
+
+	// presenceTTL 超过该时间没有收到某个实例的在线名单时，视为该实例已下线并清除其名单
+	presenceTTL = 3 * presenceSyncInterval
+
+	// presenceQueueSize 等待发布的在线状态指令数，队列满时丢弃，由下一次完整名单纠正
+	presenceQueueSize = 1024
+)
+
+// presenceCommand 实例之间同步在线状态的指令，每条指令只设置 Update、Snapshot、SyncRequest 之一
+//
+// 每个实例只发布自己的连接：连接数变化时发布 Update，定期以及收到 SyncRequest 时发布 Snapshot。
+// 指令由同一个协程按顺序发布，因此接收方按发布顺序应用即可。
+type presenceCommand struct {
+	Node string `json:"node"`
+	// Update 某个用户在发布实例上的连接数变化
+	Update *presenceUpdate `json:"update,omitempty"`
+	// Snapshot 发布实例上完整的在线名单，替换之前收到的该实例的全部状态；名单为空表示实例已下线
+	Snapshot *presenceSnapshot `json:"snapshot,omitempty"`
+	// SyncRequest 为 true 表示发布实例刚启动，其他实例应立即发布完整名单
+	SyncRequest bool `json:"sync_request,omitempty"`
+}
+
+// presenceUpdate 用户在某个实例上的连接数，Conns 为 0 表示在该实例上已离线
+type presenceUpdate struct {
+	RoomID   int    `json:"room_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Conns    int    `json:"conns"`
+}
+
+// presenceSnapshot 实例上的完整在线名单，key 为房间 ID
+type presenceSnapshot struct {
+	Rooms map[int][]models.OnlineUser `json:"rooms"`
+}
+
+// nodePresence 其他实例上报的在线名单
+type nodePresence struct {
+	// rooms key: roomID, value: map of userID -> presenceEntry
+	rooms map[int]map[int]*presenceEntry
+	// seen 最后一次收到该实例在线状态指令的时间
+	seen time.Time
+}
+
+// presenceKey 房间内的一个用户
+type presenceKey struct {
+	roomID int
+	userID int
+}
+
+// presenceChange 用户在所有实例上整体上线或离线
+type presenceChange struct {
+	presenceKey
+	username string
+	online   bool
+}
+
+// startPresenceSync 启动发布在线状态的协程，并请求其他实例发布完整名单
+func (h *Hub) startPresenceSync() {
+	h.presenceStarted.Store(true)
+	go h.syncPresence()
+
+	h.mu.Lock()
+	h.queuePresenceLocked(&presenceCommand{SyncRequest: true})
+	h.mu.Unlock()
+}
+
+// stopPresenceSync 发布完队列中的指令和空名单（通知其他实例本实例已下线）后停止
+func (h *Hub) stopPresenceSync() {
+	if !h.presenceStarted.Load() {
+		return
+	}
+	close(h.presenceStop)
+	<-h.presenceStopped
+}
+
+// syncPresence 按顺序发布在线状态指令，定期发布完整名单并清除长时间没有消息的实例
+func (h *Hub) syncPresence() {
+	defer close(h.presenceStopped)
+	ticker := time.NewTicker(presenceSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case cmd := <-h.presenceOut:
+			h.publishControl(&controlCommand{Presence: cmd})
+
+		case <-ticker.C:
+			h.mu.Lock()
+			h.queuePresenceLocked(h.presenceSnapshotLocked())
+			changes := h.expireNodesLocked(time.Now().Add(-presenceTTL))
+			h.mu.Unlock()
+			h.notifyPresence(changes)
+
+		case <-h.presenceStop:
+			// 只有本协程读取队列，长度大于 0 时读取不会阻塞
+			for len(h.presenceOut) > 0 {
+				h.publishControl(&controlCommand{Presence: <-h.presenceOut})
+			}
+			h.publishControl(&controlCommand{Presence: &presenceCommand{
+				Node:     h.node,
+				Snapshot: &presenceSnapshot{Rooms: map[int][]models.OnlineUser{}},
+			}})
+			return
+		}
+	}
+}
+
+// queuePresenceLocked 把指令放入发布队列，调用方需持有写锁以保证指令顺序与状态变化一致
+func (h *Hub) queuePresenceLocked(cmd *presenceCommand) {
+	cmd.Node = h.node
+	select {
+	case h.presenceOut <- cmd:
+	default:
+		log.Printf("Presence queue is full, dropping update until the next full sync")
+	}
+}
+
+// queuePresenceUpdateLocked 发布用户在本实例上的连接数，调用方需持有写锁
+func (h *Hub) queuePresenceUpdateLocked(client *Client, conns int) {
+	h.queuePresenceLocked(&presenceCommand{Update: &presenceUpdate{
+		RoomID:   client.RoomID,
+		UserID:   client.UserID,
+		Username: client.Username,
+		Conns:    conns,
+	}})
+}
+
+// presenceSnapshotLocked 返回本实例完整在线名单的指令，调用方需持有锁
+func (h *Hub) presenceSnapshotLocked() *presenceCommand {
+	rooms := make(map[int][]models.OnlineUser, len(h.presence))
+	for roomID, roster := range h.presence {
+		for userID, entry := range roster {
+			rooms[roomID] = append(rooms[roomID], models.OnlineUser{
+				UserID:      userID,
+				Username:    entry.Username,
+				Connections: entry.Conns,
+			})
+		}
+	}
+	return &presenceCommand{Snapshot: &presenceSnapshot{Rooms: rooms}}
+}
+
+// applyPresence 应用其他实例发布的在线状态指令，并通知本实例的客户端有用户整体上线或离线
+func (h *Hub) applyPresence(cmd *presenceCommand) {
+	if cmd.Node == "" || cmd.Node == h.node {
+		return
+	}
+
+	h.mu.Lock()
+	if cmd.SyncRequest {
+		h.queuePresenceLocked(h.presenceSnapshotLocked())
+		h.mu.Unlock()
+		return
+	}
+
+	node, ok := h.remote[cmd.Node]
+	if !ok {
+		node = &nodePresence{rooms: make(map[int]map[int]*presenceEntry)}
+		h.remote[cmd.Node] = node
+	}
+	node.seen = time.Now()
+
+	var changes []presenceChange
+	switch {
+	case cmd.Update != nil:
+		u := cmd.Update
+		key := presenceKey{u.RoomID, u.UserID}
+		changes = h.changePresenceLocked([]presenceKey{key}, func() {
+			setNodePresence(node, u.RoomID, u.UserID, u.Username, u.Conns)
+		})
+
+	case cmd.Snapshot != nil:
+		keys := node.keys()
+		for roomID, users := range cmd.Snapshot.Rooms {
+			for _, user := range users {
+				keys = append(keys, presenceKey{roomID, user.UserID})
+			}
+		}
+		changes = h.changePresenceLocked(keys, func() {
+			node.rooms = make(map[int]map[int]*presenceEntry)
+			for roomID, users := range cmd.Snapshot.Rooms {
+				for _, user := range users {
+					setNodePresence(node, roomID, user.UserID, user.Username, user.Connections)
+				}
+			}
+			if len(node.rooms) == 0 {
+				delete(h.remote, cmd.Node)
+			}
+		})
+	}
+	h.mu.Unlock()
+
+	h.notifyPresence(changes)
+}
+
+// expireNodesLocked 清除 before 之后没有消息的实例的在线名单，调用方需持有写锁
+func (h *Hub) expireNodesLocked(before time.Time) []presenceChange {
+	var changes []presenceChange
+	for id, node := range h.remote {
+		if node.seen.After(before) {
+			continue
+		}
+		log.Printf("Presence from node %s expired", id)
+		changes = append(changes, h.changePresenceLocked(node.keys(), func() {
+			delete(h.remote, id)
+		})...)
+	}
+	return changes
+}
+
+// changePresenceLocked 执行 mutate，返回 keys 中在所有实例上整体上线或离线的用户，调用方需持有写锁
+func (h *Hub) changePresenceLocked(keys []presenceKey, mutate func()) []presenceChange {
+	before := make(map[presenceKey]string, len(keys))
+	for _, key := range keys {
+		if username, ok := h.onlineAnywhereLocked(key.roomID, key.userID); ok {
+			before[key] = username
+		}
+	}
+
+	mutate()
+
+	var changes []presenceChange
+	seen := make(map[presenceKey]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		oldName, wasOnline := before[key]
+		newName, isOnline := h.onlineAnywhereLocked(key.roomID, key.userID)
+		switch {
+		case !wasOnline && isOnline:
+			changes = append(changes, presenceChange{key, newName, true})
+		case wasOnline && !isOnline:
+			changes = append(changes, presenceChange{key, oldName, false})
+		}
+	}
+	return changes
+}
+
+// notifyPresence 向本实例房间内的客户端发送 join/leave
+func (h *Hub) notifyPresence(changes []presenceChange) {
+	for _, change := range changes {
+		msgType := "leave"
+		if change.online {
+			msgType = "join"
+		}
+		h.deliver(change.roomID, models.WebSocketMessage{
+			Type:     msgType,
+			RoomID:   change.roomID,
+			UserID:   change.userID,
+			Username: change.username,
+		}, nil)
+	}
+}
+
+// onlineRemotelyLocked 判断用户是否在其他实例上在线，调用方需持有锁
+func (h *Hub) onlineRemotelyLocked(roomID, userID int) bool {
+	for _, node := range h.remote {
+		if _, ok := node.rooms[roomID][userID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// onlineAnywhereLocked 判断用户是否在任一实例上在线，并返回其用户名，调用方需持有锁
+func (h *Hub) onlineAnywhereLocked(roomID, userID int) (string, bool) {
+	if entry, ok := h.presence[roomID][userID]; ok {
+		return entry.Username, true
+	}
+	for _, node := range h.remote {
+		if entry, ok := node.rooms[roomID][userID]; ok {
+			return entry.Username, true
+		}
+	}
+	return "", false
+}
+
+// setNodePresence 设置用户在实例上的连接数，conns 为 0 时移除
+func setNodePresence(node *nodePresence, roomID, userID int, username string, conns int) {
+	roster := node.rooms[roomID]
+	if conns <= 0 {
+		delete(roster, userID)
+		if len(roster) == 0 {
+			delete(node.rooms, roomID)
+		}
+		return
+	}
+
+	if roster == nil {
+		roster = make(map[int]*presenceEntry)
+		node.rooms[roomID] = roster
+	}
+	roster[userID] = &presenceEntry{Username: username, Conns: conns}
+}
+
+// keys 返回实例上所有在线的房间用户
+func (node *nodePresence) keys() []presenceKey {
+	var keys []presenceKey
+	for roomID, roster := range node.rooms {
+		for userID := range roster {
+			keys = append(keys, presenceKey{roomID, userID})
+		}
+	}
+	return keys
+}
@@ -0,0 +1,215 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-chat/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBus 模拟多个实例共用的广播通道，每个后端按发布顺序收到其他后端的消息
+type testBus struct {
+	mu       sync.Mutex
+	backends []*testBackend
+}
+
+// testBackend 连接到 testBus 的广播后端
+type testBackend struct {
+	bus   *testBus
+	queue chan testBusMessage
+	done  chan struct{}
+}
+
+type testBusMessage struct {
+	roomID  int
+	message []byte
+}
+
+func (bus *testBus) newBackend() *testBackend {
+	b := &testBackend{bus: bus, queue: make(chan testBusMessage, 1024), done: make(chan struct{})}
+	bus.mu.Lock()
+	bus.backends = append(bus.backends, b)
+	bus.mu.Unlock()
+	return b
+}
+
+func (b *testBackend) Publish(roomID int, message []byte) error {
+	b.bus.mu.Lock()
+	defer b.bus.mu.Unlock()
+	for _, other := range b.bus.backends {
+		if other != b {
+			select {
+			case other.queue <- testBusMessage{roomID, message}:
+			case <-other.done:
+			}
+		}
+	}
+	return nil
+}
+
+func (b *testBackend) Subscribe(deliver func(roomID int, message []byte)) error {
+	go func() {
+		for {
+			select {
+			case msg := <-b.queue:
+				deliver(msg.roomID, msg.message)
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *testBackend) Close() error {
+	close(b.done)
+	return nil
+}
+
+// newTestClient 创建测试客户端
+func newTestClient(h *Hub, roomID, userID int, username string) *Client {
+	return &Client{Hub: h, RoomID: roomID, UserID: userID, Username: username, Send: make(chan []byte, 64)}
+}
+
+// nextPresence 返回客户端收到的下一条 join/leave 消息，忽略其他消息
+func nextPresence(t *testing.T, client *Client) models.WebSocketMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case data := <-client.Send:
+			var msg models.WebSocketMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if msg.Type == "join" || msg.Type == "leave" {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("no join/leave message was delivered")
+		}
+	}
+}
+
+// expectPresence 断言客户端收到的下一条 join/leave 消息
+func expectPresence(t *testing.T, client *Client, msgType string, userID int) {
+	t.Helper()
+	if msg := nextPresence(t, client); msg.Type != msgType || msg.UserID != userID {
+		t.Fatalf("got %s for user %d, want %s for user %d", msg.Type, msg.UserID, msgType, userID)
+	}
+}
+
+// waitUntil 等待 cond 成立
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// onlineConns 返回在线名单中用户的连接数，不在线时返回 0
+func onlineConns(users []models.OnlineUser, userID int) int {
+	for _, user := range users {
+		if user.UserID == userID {
+			return user.Connections
+		}
+	}
+	return 0
+}
+
+// TestPresenceAcrossNodes 两个实例的在线名单合并，join/leave 只在用户整体上线或离线时发送
+func TestPresenceAcrossNodes(t *testing.T) {
+	bus := &testBus{}
+	hubA := NewHubWithBackend(bus.newBackend())
+	hubB := NewHubWithBackend(bus.newBackend())
+	go hubA.Run()
+	go hubB.Run()
+	defer hubB.Close()
+
+	const roomID = 7
+	bob := newTestClient(hubB, roomID, 1, "bob")
+	hubB.Register(bob)
+	waitUntil(t, "node A sees bob", func() bool { return hubA.IsOnline(roomID, 1) })
+
+	// 在 A 上加入：B 上的 bob 收到 join，A 上的快照包含 B 上的 bob
+	alice := newTestClient(hubA, roomID, 2, "alice")
+	hubA.Register(alice)
+	expectPresence(t, bob, "join", 2)
+
+	var snapshot models.WebSocketMessage
+	if err := json.Unmarshal(<-alice.Send, &snapshot); err != nil || snapshot.Type != "presence" {
+		t.Fatalf("first message = %+v, %v; want presence snapshot", snapshot, err)
+	}
+	if onlineConns(snapshot.Users, 1) != 1 || onlineConns(snapshot.Users, 2) != 1 {
+		t.Fatalf("snapshot on node A = %+v, want bob and alice", snapshot.Users)
+	}
+	if users := hubB.OnlineUsers(roomID); onlineConns(users, 2) != 1 || onlineConns(users, 1) != 1 {
+		t.Fatalf("OnlineUsers on node B = %+v, want bob and alice", users)
+	}
+
+	// alice 在 B 上再打开一个连接：连接数累加，不再发送 join
+	alice2 := newTestClient(hubB, roomID, 2, "alice")
+	hubB.Register(alice2)
+	waitUntil(t, "node A counts both connections", func() bool {
+		return onlineConns(hubA.OnlineUsers(roomID), 2) == 2
+	})
+	if conns := onlineConns(hubB.OnlineUsers(roomID), 2); conns != 2 {
+		t.Fatalf("alice has %d connections on node B, want 2", conns)
+	}
+
+	// 关闭 A 上的连接后 alice 仍在 B 上在线，不发送 leave；B 上的最后一个连接关闭后才发送
+	hubA.Unregister(alice)
+	waitUntil(t, "node B sees alice leave node A", func() bool {
+		return onlineConns(hubB.OnlineUsers(roomID), 2) == 1
+	})
+	hubB.Unregister(alice2)
+	expectPresence(t, bob, "leave", 2)
+	if hubB.IsOnline(roomID, 2) {
+		t.Fatal("alice is still online on node B after closing all connections")
+	}
+	waitUntil(t, "node A sees alice leave", func() bool { return !hubA.IsOnline(roomID, 2) })
+
+	// 实例关闭时其他实例清除它的名单
+	alice = newTestClient(hubA, roomID, 2, "alice")
+	hubA.Register(alice)
+	expectPresence(t, bob, "join", 2)
+	hubA.Close()
+	expectPresence(t, bob, "leave", 2)
+	if users := hubB.OnlineUsers(roomID); len(users) != 1 || users[0].UserID != 1 {
+		t.Fatalf("OnlineUsers on node B after node A closed = %+v, want only bob", users)
+	}
+}
+
+// TestPresenceExpiry 长时间没有消息的实例的名单被清除
+func TestPresenceExpiry(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	const roomID = 7
+	bob := newTestClient(h, roomID, 1, "bob")
+	h.Register(bob)
+
+	h.applyPresence(&presenceCommand{Node: "other", Update: &presenceUpdate{RoomID: roomID, UserID: 2, Username: "alice", Conns: 1}})
+	expectPresence(t, bob, "join", 2)
+
+	h.mu.Lock()
+	changes := h.expireNodesLocked(time.Now().Add(-presenceTTL))
+	h.mu.Unlock()
+	if len(changes) != 0 {
+		t.Fatalf("node expired early: %+v", changes)
+	}
+
+	h.mu.Lock()
+	changes = h.expireNodesLocked(time.Now().Add(time.Second))
+	h.mu.Unlock()
+	h.notifyPresence(changes)
+	expectPresence(t, bob, "leave", 2)
+	if h.IsOnline(roomID, 2) {
+		t.Fatal("alice is still online after node expired")
+	}
+}
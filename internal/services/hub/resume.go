@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-chat/internal/models"
+)
+
+// MaxReplayMessages 断线重连时最多补发的消息数量
+// 必须小于客户端发送通道的容量，超过时客户端需要重新加载页面
+const MaxReplayMessages = 200
+
+// heldMessage 用于判断暂存的实时消息是否已经包含在补发内容中
+type heldMessage struct {
+	Type    string `json:"type"`
+	Message *struct {
+		ID int `json:"id"`
+	} `json:"message"`
+}
+
+// Resume 补发断线期间错过的消息，然后切换到实时投递
+//
+// 客户端以 Resuming=true 注册后，Hub 会暂存发往它的实时消息；调用方在注册之后
+// 从数据库查询 ID 大于 LastMessageID 的消息并传入（按 ID 升序，最多
+// MaxReplayMessages+1 条）。补发完成后再按顺序投递暂存的实时消息，并跳过
+// 已经补发过的部分，从而保证交接过程中既不重复也不遗漏。
+func (c *Client) Resume(missed []models.Message) error {
+	h := c.Hub
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.rooms[c.RoomID][c] {
+		return errClientGone
+	}
+
+	held := c.held
+	c.held = nil
+	c.resuming = false
+
+	// 错过的消息太多，让客户端重新加载而不是逐条补发
+	if len(missed) > MaxReplayMessages {
+		return c.pushLocked(models.WebSocketMessage{Type: "resync", RoomID: c.RoomID})
+	}
+
+	lastID := c.LastMessageID
+	for i := range missed {
+		if err := c.pushLocked(models.WebSocketMessage{
+			Type:    "message",
+			RoomID:  c.RoomID,
+			Message: &missed[i],
+		}); err != nil {
+			return err
+		}
+		if missed[i].ID > lastID {
+			lastID = missed[i].ID
+		}
+	}
+
+	if err := c.pushLocked(models.WebSocketMessage{
+		Type:          "resumed",
+		RoomID:        c.RoomID,
+		LastMessageID: lastID,
+	}); err != nil {
+		return err
+	}
+
+	for _, data := range held {
+		var msg heldMessage
+		if err := json.Unmarshal(data, &msg); err == nil &&
+			msg.Type == "message" && msg.Message != nil && msg.Message.ID <= lastID {
+			continue
+		}
+
+		select {
+		case c.Send <- data:
+		default:
+			return errSendBufferFull
+		}
+	}
+
+	return nil
+}
+
+// pushLocked 向客户端发送通道写入消息，调用方需持有 Hub 的锁
+func (c *Client) pushLocked(message models.WebSocketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.Send <- data:
+		return nil
+	default:
+		return errSendBufferFull
+	}
+}
+
+// enqueueLocked 投递一条实时消息，调用方需持有 Hub 的写锁
+// 补发完成前消息暂存在 held 中，返回 false 表示缓冲区已满
+func (c *Client) enqueueLocked(data []byte) bool {
+	if c.resuming {
+		if len(c.held) >= cap(c.Send) {
+			return false
+		}
+		c.held = append(c.held, data)
+		return true
+	}
+
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
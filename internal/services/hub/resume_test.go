@@ -0,0 +1,44 @@
+package hub
+
+import (
+	"encoding/json"
+	"go-chat/internal/models"
+	"testing"
+)
+
+// TestResumeAfterRegister Register 返回后客户端已在房间内，立即补发不应返回 errClientGone
+func TestResumeAfterRegister(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	for i := 0; i < 100; i++ {
+		client := &Client{
+			Hub:           h,
+			RoomID:        1,
+			UserID:        i + 1,
+			Username:      "user",
+			Send:          make(chan []byte, 16),
+			Resuming:      true,
+			LastMessageID: 10,
+		}
+		h.Register(client)
+
+		if err := client.Resume([]models.Message{{ID: 11, RoomID: 1}}); err != nil {
+			t.Fatalf("Resume after Register: %v", err)
+		}
+
+		var types []string
+		for len(client.Send) > 0 {
+			var msg models.WebSocketMessage
+			if err := json.Unmarshal(<-client.Send, &msg); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			types = append(types, msg.Type)
+		}
+		if len(types) != 3 || types[0] != "presence" || types[1] != "message" || types[2] != "resumed" {
+			t.Fatalf("messages = %v, want [presence message resumed]", types)
+		}
+
+		h.Unregister(client)
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OutboxMailer 不真正发送邮件，而是把邮件写入目录中的文件并打印日志，用于本地开发和测试
+type OutboxMailer struct {
+	dir string
+}
+
+// NewOutboxMailer 创建 OutboxMailer，dir 目录不存在时会自动创建
+func NewOutboxMailer(dir string) (*OutboxMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return &OutboxMailer{dir: dir}, nil
+}
+
+// Send 把邮件写入 <dir>/<时间戳>.eml
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	if err := validHeader(msg.To); err != nil {
+		return err
+	}
+	if err := validHeader(msg.Subject); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	name := time.Now().Format("20060102-150405.000000000") + ".eml"
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return err
+	}
+
+	log.Printf("Mail to %s saved to %s: %s", msg.To, path, msg.Subject)
+	return nil
+}
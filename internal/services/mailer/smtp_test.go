@@ -0,0 +1,148 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer 只支持最基本命令的 SMTP 服务器，收到的邮件内容写入 received
+func fakeSMTPServer(t *testing.T, conn net.Conn, received chan<- string) {
+	t.Helper()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"), strings.HasPrefix(cmd, "RCPT TO:"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			received <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// newTestMailer 创建连接到 ln 的 SMTPMailer
+func newTestMailer(t *testing.T, ln net.Listener) *SMTPMailer {
+	t.Helper()
+	addr := ln.Addr().(*net.TCPAddr)
+	m, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "Go Chat <noreply@example.com>"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer: %v", err)
+	}
+	return m
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		fakeSMTPServer(t, conn, received)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = newTestMailer(t, ln).Send(ctx, Message{To: "alice@example.com", Subject: "你好", Body: "hello"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	data := <-received
+	for _, want := range []string{"To: <alice@example.com>\r\n", "Subject: =?utf-8?q?", "\r\n\r\nhello"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+// TestSMTPMailerSendTimeout 服务器不响应时 Send 在 ctx 到期后返回，并关闭连接
+func TestSMTPMailerSendTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// 不发送问候语，等待客户端断开
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = newTestMailer(t, ln).Send(ctx, Message{To: "alice@example.com", Subject: "hi", Body: "hello"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Send returned after %v", elapsed)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not closed after the deadline")
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: 25, From: "noreply@example.com"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer: %v", err)
+	}
+	if err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "hi\r\nBcc: eve@example.com"}); err == nil {
+		t.Fatal("Send accepted a subject containing a newline")
+	}
+}
@@ -0,0 +1,63 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// validName 身份提供方标识只能包含小写字母、数字、- 和 _
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ProvidersFromEnv 根据环境变量创建身份提供方，未配置时返回空列表
+//
+// OIDC_PROVIDERS 为逗号分隔的标识列表，每个标识 <name> 对应以下环境变量（<NAME> 为大写，- 换成 _）：
+// OIDC_<NAME>_ISSUER、OIDC_<NAME>_CLIENT_ID、OIDC_<NAME>_CLIENT_SECRET（必填），
+// OIDC_<NAME>_DISPLAY_NAME（默认为标识）、OIDC_<NAME>_SCOPES（空格分隔，默认 "openid email profile"）。
+// 回调地址为 <baseURL>/auth/oidc/<name>/callback。
+func ProvidersFromEnv(baseURL string) ([]*Provider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var providers []*Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := Config{
+			Name:         name,
+			DisplayName:  getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimRight(baseURL, "/") + "/auth/oidc/" + name + "/callback",
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		}
+		if !contains(cfg.Scopes, "openid") {
+			cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("OIDC provider %q requires %sISSUER, %sCLIENT_ID and %sCLIENT_SECRET", name, prefix, prefix, prefix)
+		}
+
+		providers = append(providers, NewProvider(cfg, client))
+	}
+	return providers, nil
+}
+
+// getEnv 获取环境变量，如果不存在则返回默认值
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
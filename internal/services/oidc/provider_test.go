@@ -0,0 +1,474 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "go-chat"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://chat.example.com/auth/oidc/test/callback"
+)
+
+// testNow 测试中 Provider 和模拟 IdP 共用的当前时间
+var testNow = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// signingKey IdP 的一把签名密钥
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newSigningKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return signingKey{kid: kid, key: key}
+}
+
+// authRequest 授权时记录的请求参数，换取令牌时用于校验 PKCE 并填写 nonce
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+// mockIdP 使用 httptest 模拟的 OIDC 身份提供方，提供 discovery、JWKS 和令牌接口
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu          sync.Mutex
+	keys        []signingKey // 发布在 JWKS 中的公钥，第一把用于签名
+	authMethods []string
+	codes       map[string]authRequest
+	jwksFetches int
+	// claims 修改即将签发的 ID Token 声明
+	claims func(map[string]interface{})
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{
+		t:     t,
+		keys:  []signingKey{newSigningKey(t, "key-1")},
+		codes: make(map[string]authRequest),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("/jwks", idp.handleJWKS)
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// provider 返回指向模拟 IdP 的 Provider，当前时间固定为 testNow
+func (idp *mockIdP) provider() *Provider {
+	p := NewProvider(Config{
+		Name:         "test",
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	}, idp.server.Client())
+	p.now = func() time.Time { return testNow }
+	return p
+}
+
+func (idp *mockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                idp.server.URL,
+		"authorization_endpoint":                idp.server.URL + "/authorize",
+		"token_endpoint":                        idp.server.URL + "/token",
+		"jwks_uri":                              idp.server.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": idp.authMethods,
+	})
+}
+
+func (idp *mockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwksFetches++
+
+	var keys []map[string]string
+	for _, k := range idp.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": k.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// handleToken 校验客户端凭据和 PKCE verifier 后签发 ID Token
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	methods := idp.authMethods
+	req, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	clientID, secret, basic := r.BasicAuth()
+	if len(methods) == 1 && methods[0] == "client_secret_post" {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		basic = true
+	}
+	switch {
+	case !basic || clientID != testClientID || secret != testClientSecret:
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	case r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != testRedirectURL:
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	case !ok || CodeChallenge(r.PostForm.Get("code_verifier")) != req.challenge:
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idp.idToken(req.nonce),
+	})
+}
+
+// authorize 模拟用户在 IdP 登录：解析授权地址并返回授权码
+func (idp *mockIdP) authorize(authURL string) string {
+	idp.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatalf("parse auth URL: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		idp.t.Fatalf("auth URL has no S256 code challenge: %s", authURL)
+	}
+
+	code := "code-" + query.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = authRequest{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	idp.mu.Unlock()
+	return code
+}
+
+// idToken 用第一把密钥签发 ID Token，claims 钩子可以修改默认声明
+func (idp *mockIdP) idToken(nonce string) string {
+	claims := map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "user-123",
+		"aud":            testClientID,
+		"exp":            testNow.Add(time.Hour).Unix(),
+		"iat":            testNow.Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	idp.mu.Lock()
+	key := idp.keys[0]
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+	idp.mu.Unlock()
+	return signJWT(idp.t, key, "RS256", claims)
+}
+
+// signJWT 用 RS256 签名 JWT；alg 只写入头部，用于构造算法不符的令牌
+func signJWT(t *testing.T, key signingKey, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": key.kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login 走完整的授权码流程：生成授权地址、在 IdP 登录、用授权码换取令牌
+func login(t *testing.T, idp *mockIdP, p *Provider, verifier, nonce string) (*Claims, error) {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "idp-"+nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := idp.authorize(authURL)
+	return p.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.server.URL+"/authorize" {
+		t.Errorf("endpoint = %q, want %q", got, idp.server.URL+"/authorize")
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = func(c map[string]interface{}) { c["nonce"] = "nonce-1" }
+	p := idp.provider()
+
+	claims, err := login(t, idp, p, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "alice@example.com" || !bool(claims.EmailVerified) {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestExchangeClientSecretPost(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.authMethods = []string{"client_secret_post"}
+	idp.claims = func(c map[string]interface{}) { c["nonce"] = "nonce-1" }
+
+	if _, err := login(t, idp, idp.provider(), "verifier-1", "nonce-1"); err != nil {
+		t.Fatalf("Exchange with client_secret_post: %v", err)
+	}
+}
+
+func TestExchangePKCE(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = func(c map[string]interface{}) { c["nonce"] = "nonce-1" }
+	p := idp.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := idp.authorize(authURL)
+
+	// 授权码被截获后，没有原始 verifier 无法换取令牌
+	_, err = p.Exchange(context.Background(), code, "another-verifier", "nonce-1")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange with wrong verifier: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	// IdP 签发的令牌带有授权请求中的 nonce，与本次登录保存的 nonce 不同
+	_, err := login(t, idp, p, "verifier-1", "nonce-1")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("Exchange with mismatched nonce: err = %v", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	p.Issuer = idp.server.URL + "/"
+
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("AuthCodeURL succeeded with mismatched discovery issuer")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idp := newMockIdP(t)
+	otherKey := newSigningKey(t, "key-1")
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   idp.server.URL,
+			"sub":   "user-123",
+			"aud":   testClientID,
+			"exp":   testNow.Add(time.Hour).Unix(),
+			"iat":   testNow.Unix(),
+			"nonce": "nonce-1",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     signingKey
+		alg     string
+		modify  func(map[string]interface{})
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "audience list with azp", modify: func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+		}},
+		{name: "expired within clock skew", modify: func(c map[string]interface{}) {
+			c["exp"] = testNow.Add(-clockSkew / 2).Unix()
+		}},
+		{name: "nonce mismatch", modify: func(c map[string]interface{}) { c["nonce"] = "nonce-2" }, wantErr: "nonce"},
+		{name: "missing nonce", modify: func(c map[string]interface{}) { delete(c, "nonce") }, wantErr: "nonce"},
+		{name: "wrong issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, wantErr: "issuer"},
+		{name: "wrong audience", modify: func(c map[string]interface{}) { c["aud"] = "other-client" }, wantErr: "not issued for this client"},
+		{name: "audience list without azp", modify: func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+		}, wantErr: "authorized party"},
+		{name: "wrong azp", modify: func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+		}, wantErr: "authorized party"},
+		{name: "missing subject", modify: func(c map[string]interface{}) { delete(c, "sub") }, wantErr: "subject"},
+		{name: "expired", modify: func(c map[string]interface{}) {
+			c["exp"] = testNow.Add(-2 * clockSkew).Unix()
+		}, wantErr: "expired"},
+		{name: "issued in the future", modify: func(c map[string]interface{}) {
+			c["iat"] = testNow.Add(2 * clockSkew).Unix()
+		}, wantErr: "future"},
+		{name: "HS256 header", alg: "HS256", wantErr: "algorithm"},
+		{name: "none header", alg: "none", wantErr: "algorithm"},
+		{name: "signed by another key", key: otherKey, wantErr: "signature"},
+		{name: "unknown kid", key: signingKey{kid: "key-2", key: otherKey.key}, wantErr: "unknown signing key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			key := tt.key
+			if key.key == nil {
+				key = idp.keys[0]
+			}
+			alg := tt.alg
+			if alg == "" {
+				alg = "RS256"
+			}
+
+			_, err := idp.provider().Verify(context.Background(), signJWT(t, key, alg, claims), "nonce-1")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Verify: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Verify: err = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyExpiryUsesClock 有效期按 Provider 的时钟判断，令牌在签发后随时间推移失效
+func TestVerifyExpiryUsesClock(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	token := signJWT(t, idp.keys[0], "RS256", map[string]interface{}{
+		"iss":   idp.server.URL,
+		"sub":   "user-123",
+		"aud":   testClientID,
+		"exp":   testNow.Add(5 * time.Minute).Unix(),
+		"iat":   testNow.Unix(),
+		"nonce": "nonce-1",
+	})
+
+	if _, err := p.Verify(context.Background(), token, "nonce-1"); err != nil {
+		t.Fatalf("Verify before expiry: %v", err)
+	}
+
+	p.now = func() time.Time { return testNow.Add(5*time.Minute + clockSkew + time.Second) }
+	if _, err := p.Verify(context.Background(), token, "nonce-1"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Verify after expiry: err = %v, want expired", err)
+	}
+}
+
+// TestKeyRotation IdP 轮换密钥后，遇到未知 kid 时按最小间隔重新获取 JWKS
+func TestKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	now := testNow
+	p.now = func() time.Time { return now }
+
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"sub":   "user-123",
+		"aud":   testClientID,
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"iat":   testNow.Unix(),
+		"nonce": "nonce-1",
+	}
+	if _, err := p.Verify(context.Background(), signJWT(t, idp.keys[0], "RS256", claims), "nonce-1"); err != nil {
+		t.Fatalf("Verify with original key: %v", err)
+	}
+
+	rotated := newSigningKey(t, "key-2")
+	idp.mu.Lock()
+	idp.keys = append([]signingKey{rotated}, idp.keys...)
+	idp.mu.Unlock()
+	token := signJWT(t, rotated, "RS256", claims)
+
+	// 刚获取过 JWKS，未知 kid 不会立即触发重新获取
+	if _, err := p.Verify(context.Background(), token, "nonce-1"); err == nil {
+		t.Fatal("Verify with rotated key succeeded before the minimum refresh interval")
+	}
+
+	now = now.Add(jwksMinRefresh)
+	if _, err := p.Verify(context.Background(), token, "nonce-1"); err != nil {
+		t.Fatalf("Verify with rotated key after refresh interval: %v", err)
+	}
+
+	idp.mu.Lock()
+	fetches := idp.jwksFetches
+	idp.mu.Unlock()
+	if fetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", fetches)
+	}
+}
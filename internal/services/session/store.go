@@ -0,0 +1,272 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"go-chat/internal/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultMaxAge session 默认有效期（秒）
+const DefaultMaxAge = 7 * 24 * 60 * 60
+
+// Store 基于 Postgres sessions 表的 gorilla sessions.Store
+//
+// Cookie 中只保存签名后的 session ID，session 数据和过期时间保存在数据库中，
+// 因此删除数据库中的记录即可让对应的登录立即失效。
+type Store struct {
+	db      *sql.DB
+	codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// NewStore 创建 Store，keyPairs 用于签名（和可选地加密）cookie 中的 session ID
+func NewStore(db *sql.DB, keyPairs ...[]byte) *Store {
+	return &Store{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   DefaultMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Get 获取请求的 session，同一请求内多次调用返回同一个 session
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New 根据 cookie 从数据库加载 session；cookie 无效、session 已过期或已被撤销时返回新的空 session
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	var data string
+	err = s.db.QueryRow(
+		"SELECT data FROM sessions WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP",
+		id,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	if err := decodeValues(data, &session.Values); err != nil {
+		log.Printf("Error decoding session data: %v", err)
+		return session, nil
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save 保存 session 并写入 cookie；Options.MaxAge < 0 时删除 session
+// session 没有 ID 时（新 session 或调用方要求更换 ID）会生成新的 ID
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.Revoke(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	data, err := encodeValues(session.Values)
+	if err != nil {
+		return err
+	}
+
+	// 未登录的 session 没有 user_id
+	var userID sql.NullInt64
+	if id, ok := session.Values["user_id"].(int); ok {
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO sessions (id, user_id, data, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4))
+		ON CONFLICT (id) DO UPDATE SET user_id = EXCLUDED.user_id, data = EXCLUDED.data, expires_at = EXCLUDED.expires_at
+	`, session.ID, userID, data, maxAge); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// touchInterval 更新 last_seen_at 的最小间隔，避免每个请求都写数据库
+const touchInterval = time.Minute
+
+// Touch 记录 session 的最近活动时间、IP 和 User-Agent
+func (s *Store) Touch(id, ip, userAgent string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = $2, user_agent = $3
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < CURRENT_TIMESTAMP - make_interval(secs => $4)
+		                   OR ip <> $2 OR user_agent <> $3)
+	`, id, ip, userAgent, touchInterval.Seconds())
+	return err
+}
+
+// ListUser 返回用户未过期的 session，按最近活动时间倒序
+func (s *Store) ListUser(userID int) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, public_id, user_id, ip, user_agent, last_seen_at, expires_at, created_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY COALESCE(last_seen_at, created_at) DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var sess models.Session
+		if err := rows.Scan(
+			&sess.ID, &sess.PublicID, &sess.UserID, &sess.IP, &sess.UserAgent,
+			&sess.LastSeenAt, &sess.ExpiresAt, &sess.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokePublic 删除用户的一个 session（按 public_id），返回被删除的 session ID
+// session 不存在或不属于该用户时返回 sql.ErrNoRows
+func (s *Store) RevokePublic(userID int, publicID int64) (string, error) {
+	var id string
+	err := s.db.QueryRow(
+		"DELETE FROM sessions WHERE public_id = $1 AND user_id = $2 RETURNING id",
+		publicID, userID,
+	).Scan(&id)
+	return id, err
+}
+
+// RevokeOthers 删除用户除 keepID 以外的全部 session，返回删除的数量
+func (s *Store) RevokeOthers(userID int, keepID string) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Revoke 删除一个 session，持有该 session 的客户端下次请求时即被视为未登录
+func (s *Store) Revoke(id string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE id = $1", id)
+	return err
+}
+
+// RevokeUser 删除用户的全部 session，让用户在所有设备上退出登录
+func (s *Store) RevokeUser(userID int) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	return err
+}
+
+// Cleanup 删除已过期的 session，返回删除的数量
+func (s *Store) Cleanup() (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartCleanup 每隔 interval 清理一次过期 session，返回的函数用于停止清理
+func (s *Store) StartCleanup(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				n, err := s.Cleanup()
+				if err != nil {
+					log.Printf("Error cleaning up sessions: %v", err)
+				} else if n > 0 {
+					log.Printf("Cleaned up %d expired sessions", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// newID 生成随机 session ID
+func newID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// encodeValues 用 gob 序列化 session 数据，保存为 base64 文本
+func encodeValues(values map[interface{}]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeValues 反序列化 encodeValues 保存的数据
+func decodeValues(data string, values *map[interface{}]interface{}) error {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(values)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("object not found")
+
+// Store 二进制对象存储接口，key 由调用方生成，只包含 [a-z0-9/._-]
+type Store interface {
+	// Put 写入对象，size 为内容长度
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Open 读取对象，调用方负责关闭
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromEnv 根据环境变量创建存储
+// STORAGE_BACKEND=local（默认）时使用 STORAGE_LOCAL_DIR 目录；
+// STORAGE_BACKEND=s3 时使用 S3_ENDPOINT、S3_BUCKET、S3_REGION、S3_ACCESS_KEY、S3_SECRET_KEY
+func NewFromEnv() (Store, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		return NewLocalStore(getEnv("STORAGE_LOCAL_DIR", "data/uploads"))
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// getEnv 获取环境变量，如果不存在则返回默认值
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
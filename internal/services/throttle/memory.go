@@ -0,0 +1,81 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内的失败计数存储，只适用于单实例部署，重启后清空
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore 创建 MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Attempt 在锁内检查并计入一次尝试
+func (s *MemoryStore) Attempt(key string, now time.Time, policy Policy) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.records[key]
+	if wait := policy.wait(rec, now); wait > 0 {
+		return Attempt{Key: key, Wait: wait}, nil
+	}
+
+	previous := rec.LastFailure
+	if now.Sub(rec.LastFailure) > policy.Window {
+		rec.Failures = 0
+	}
+	rec.Failures++
+	rec.LastFailure = now
+	s.records[key] = rec
+	return Attempt{Key: key, Record: rec, Previous: previous}, nil
+}
+
+// Refund 撤销一次计入的尝试
+func (s *MemoryStore) Refund(a Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[a.Key]
+	if !ok {
+		return nil
+	}
+	rec.Failures--
+	if rec.Failures <= 0 {
+		delete(s.records, a.Key)
+		return nil
+	}
+	if rec.LastFailure.Equal(a.Record.LastFailure) {
+		rec.LastFailure = a.Previous
+	}
+	s.records[a.Key] = rec
+	return nil
+}
+
+// Reset 清除 key 的失败记录
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// Cleanup 删除最后一次失败早于 before 的记录
+func (s *MemoryStore) Cleanup(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, rec := range s.records {
+		if rec.LastFailure.Before(before) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package throttle
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresStore 基于 login_throttle 表的失败计数存储，多个实例共享计数
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore 创建 PostgresStore
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Attempt 用一条 upsert 检查并计入一次尝试，并发的尝试由行锁串行化
+// 等待时间在 SQL 中按 Policy.delay 的规则计算；还需要等待时 upsert 不更新任何行
+func (s *PostgresStore) Attempt(key string, now time.Time, policy Policy) (Attempt, error) {
+	a := Attempt{Key: key}
+	err := s.db.QueryRow(`
+		WITH previous AS (
+			SELECT last_failure_at FROM login_throttle WHERE key = $1
+		)
+		INSERT INTO login_throttle AS t (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN t.last_failure_at < $3 THEN 1 ELSE t.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		WHERE t.last_failure_at < $3 OR t.last_failure_at + make_interval(secs =>
+			CASE
+				WHEN $4::int > 0 AND t.failures >= $4::int THEN $5::float8
+				WHEN t.failures <= $6::int THEN 0
+				ELSE LEAST($7::float8 * power(2, LEAST(t.failures - $6::int - 1, 30)), $8::float8)
+			END) <= $2
+		RETURNING t.failures, t.last_failure_at, COALESCE((SELECT last_failure_at FROM previous), 'epoch')
+	`, key, now, now.Add(-policy.Window),
+		policy.LockoutAfter, policy.LockoutDuration.Seconds(),
+		policy.FreeAttempts, policy.BaseDelay.Seconds(), policy.MaxDelay.Seconds(),
+	).Scan(&a.Record.Failures, &a.Record.LastFailure, &a.Previous)
+	if err != sql.ErrNoRows {
+		return a, err
+	}
+
+	// 没有更新说明还需要等待，读取记录计算等待时间
+	var rec Record
+	if err := s.db.QueryRow(
+		"SELECT failures, last_failure_at FROM login_throttle WHERE key = $1", key,
+	).Scan(&rec.Failures, &rec.LastFailure); err != nil && err != sql.ErrNoRows {
+		return a, err
+	}
+	a.Wait = policy.wait(rec, now)
+	if a.Wait <= 0 {
+		// 记录在两次查询之间被清除或更新，让调用方稍后重试
+		a.Wait = time.Second
+	}
+	return a, nil
+}
+
+// Refund 撤销一次计入的尝试；之后有新的尝试时保留其时间
+func (s *PostgresStore) Refund(a Attempt) error {
+	_, err := s.db.Exec(`
+		UPDATE login_throttle SET
+			failures = GREATEST(failures - 1, 0),
+			last_failure_at = CASE WHEN last_failure_at = $2 THEN $3 ELSE last_failure_at END
+		WHERE key = $1
+	`, a.Key, a.Record.LastFailure, a.Previous)
+	return err
+}
+
+// Reset 清除 key 的失败记录
+func (s *PostgresStore) Reset(key string) error {
+	_, err := s.db.Exec("DELETE FROM login_throttle WHERE key = $1", key)
+	return err
+}
+
+// Cleanup 删除最后一次失败早于 before 的记录
+func (s *PostgresStore) Cleanup(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM login_throttle WHERE last_failure_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter 进程内的令牌桶限流：每个 key 最多连续 burst 次，之后每 interval 恢复一次
+// 多实例部署时每个实例分别计数
+type RateLimiter struct {
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// bucket 一个 key 的令牌桶
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter 创建 RateLimiter
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	return &RateLimiter{
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Allow 消耗 key 的一次额度，额度不足时返回 false 和需要等待的时间
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(float64(l.burst), b.tokens+float64(now.Sub(b.updated))/float64(l.interval))
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune 每隔一段时间删除已经恢复满额的桶，避免 key 越来越多
+func (l *RateLimiter) prune(now time.Time) {
+	full := l.interval * time.Duration(l.burst)
+	if now.Sub(l.lastPrune) < full {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package throttle 按 key（如用户名、IP）统计连续失败次数，并据此计算需要等待的时间
+package throttle
+
+import (
+	"log"
+	"time"
+)
+
+// Record 一个 key 的失败记录
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Attempt 一次尝试的结果
+// 尝试在开始时就计为一次失败，这样并发的请求也只能按策略逐个通过；
+// 尝试最终成功时调用方通过 Refund 撤销计数，或通过 Reset 清除全部记录
+type Attempt struct {
+	// Key 包含前缀的完整 key
+	Key string
+	// Wait 大于 0 表示还需要等待，这次尝试被拒绝且没有计数
+	Wait time.Duration
+	// Record 计入这次尝试后的记录，LastFailure 即这次尝试的时间
+	Record Record
+	// Previous 计入之前的最后一次失败时间，撤销时恢复
+	Previous time.Time
+}
+
+// Store 失败计数存储
+type Store interface {
+	// Attempt 原子地检查并计入一次尝试：按 policy 还需要等待时只返回等待时间；
+	// 否则计为一次失败（上一次失败早于 policy.Window 时从 1 重新计数）
+	Attempt(key string, now time.Time, policy Policy) (Attempt, error)
+	// Refund 撤销一次计入的尝试：失败次数减一，之后没有新的尝试时恢复最后一次失败时间
+	Refund(a Attempt) error
+	// Reset 清除 key 的失败记录
+	Reset(key string) error
+	// Cleanup 删除最后一次失败早于 before 的记录，返回删除的数量
+	Cleanup(before time.Time) (int64, error)
+}
+
+// Policy 限流策略
+type Policy struct {
+	// FreeAttempts 不需要等待的失败次数
+	FreeAttempts int
+	// BaseDelay 超过 FreeAttempts 后第一次失败的等待时间，之后每失败一次翻倍，最长 MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter 失败次数达到该值后锁定 LockoutDuration，为 0 表示不锁定
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window 最后一次失败超过该时间后重新计数
+	Window time.Duration
+}
+
+// Limiter 按 Policy 对一类 key 限流，不同类的 key 用 prefix 区分后共享同一个 Store
+type Limiter struct {
+	store  Store
+	prefix string
+	policy Policy
+	now    func() time.Time
+}
+
+// NewLimiter 创建 Limiter
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		prefix: prefix + ":",
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// delay 返回失败 failures 次后需要等待的时间，以及是否处于锁定状态
+func (p Policy) delay(failures int) (time.Duration, bool) {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration, true
+	}
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d, false
+}
+
+// wait 返回失败记录为 rec 的 key 在 now 时还需要等待的时间
+func (p Policy) wait(rec Record, now time.Time) time.Duration {
+	if rec.Failures == 0 || now.Sub(rec.LastFailure) > p.Window {
+		return 0
+	}
+
+	d, _ := p.delay(rec.Failures)
+	if wait := rec.LastFailure.Add(d).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Attempt 开始一次尝试，返回的 Wait 大于 0 时需要拒绝请求
+// 尝试失败时保留计数；成功时调用 Refund 或 Reset
+func (l *Limiter) Attempt(key string) (Attempt, error) {
+	return l.store.Attempt(l.prefix+key, l.now(), l.policy)
+}
+
+// Locked 判断这次尝试失败后是否使 key 进入锁定状态
+func (l *Limiter) Locked(a Attempt) bool {
+	return a.Wait == 0 && l.policy.LockoutAfter > 0 && a.Record.Failures == l.policy.LockoutAfter
+}
+
+// Refund 撤销一次成功尝试的计数，不影响之前的失败记录
+func (l *Limiter) Refund(a Attempt) error {
+	if a.Wait > 0 {
+		return nil
+	}
+	return l.store.Refund(a)
+}
+
+// Reset 清除 key 的失败记录（登录成功或管理员解锁）
+func (l *Limiter) Reset(key string) error {
+	return l.store.Reset(l.prefix + key)
+}
+
+// StartCleanup 每隔 interval 清理一次超过 maxAge 没有失败的记录，返回的函数用于停止清理
+func StartCleanup(store Store, interval, maxAge time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.Cleanup(time.Now().Add(-maxAge))
+				if err != nil {
+					log.Printf("Error cleaning up login throttle records: %v", err)
+				} else if n > 0 {
+					log.Printf("Cleaned up %d login throttle records", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,169 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          24 * time.Hour,
+}
+
+// newTestLimiter 返回使用内存存储和可控时钟的 Limiter
+func newTestLimiter(now *time.Time) *Limiter {
+	l := NewLimiter(NewMemoryStore(), "user", testPolicy)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+		locked   bool
+	}{
+		{0, 0, false},
+		{3, 0, false},
+		{4, time.Second, false},
+		{5, 2 * time.Second, false},
+		{6, 4 * time.Second, false},
+		{9, 32 * time.Second, false},
+		{10, 15 * time.Minute, true},
+		{11, 15 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		got, locked := testPolicy.delay(tt.failures)
+		if got != tt.want || locked != tt.locked {
+			t.Errorf("delay(%d) = %v, %v; want %v, %v", tt.failures, got, locked, tt.want, tt.locked)
+		}
+	}
+}
+
+// TestAttemptConcurrent 同时到达的尝试只有免等待的次数能通过，其余需要等待
+func TestAttemptConcurrent(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(&now)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, err := l.Attempt("alice")
+			if err != nil {
+				t.Errorf("Attempt: %v", err)
+				return
+			}
+			if a.Wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 前 3 次失败不需要等待，第 4 次尝试也可以立即进行，之后需要等待 1 秒
+	if allowed != testPolicy.FreeAttempts+1 {
+		t.Fatalf("allowed %d concurrent attempts, want %d", allowed, testPolicy.FreeAttempts+1)
+	}
+}
+
+func TestAttemptBackoffAndLockout(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(&now)
+
+	for i := 1; i <= testPolicy.LockoutAfter; i++ {
+		a, err := l.Attempt("alice")
+		if err != nil {
+			t.Fatalf("Attempt: %v", err)
+		}
+		if a.Wait != 0 {
+			t.Fatalf("attempt %d: wait %v, want 0", i, a.Wait)
+		}
+		if a.Record.Failures != i {
+			t.Fatalf("attempt %d: failures = %d", i, a.Record.Failures)
+		}
+		if locked := l.Locked(a); locked != (i == testPolicy.LockoutAfter) {
+			t.Fatalf("attempt %d: locked = %v", i, locked)
+		}
+
+		// 等到可以再次尝试
+		d, _ := testPolicy.delay(i)
+		now = now.Add(d)
+		if i < testPolicy.LockoutAfter && d > 0 {
+			now = now.Add(-time.Millisecond)
+			if a, _ := l.Attempt("alice"); a.Wait != time.Millisecond {
+				t.Fatalf("attempt %d: wait %v before delay elapsed", i, a.Wait)
+			}
+			now = now.Add(time.Millisecond)
+		}
+	}
+
+	// 锁定期满后可以再次尝试
+	a, err := l.Attempt("alice")
+	if err != nil || a.Wait != 0 {
+		t.Fatalf("after lockout: wait %v, err %v", a.Wait, err)
+	}
+}
+
+// TestRefund 撤销成功的尝试后保留之前的失败记录，等待时间不受这次尝试影响
+func TestRefund(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 4; i++ {
+		if _, err := l.Attempt("alice"); err != nil {
+			t.Fatalf("Attempt: %v", err)
+		}
+	}
+	lastFailure := now
+
+	now = now.Add(time.Second)
+	a, err := l.Attempt("alice")
+	if err != nil || a.Wait != 0 {
+		t.Fatalf("Attempt: wait %v, err %v", a.Wait, err)
+	}
+	if err := l.Refund(a); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	rec := l.store.(*MemoryStore).records["user:alice"]
+	if rec.Failures != 4 || !rec.LastFailure.Equal(lastFailure) {
+		t.Fatalf("after refund: %+v, want 4 failures at %v", rec, lastFailure)
+	}
+
+	// 撤销唯一的一次尝试后不留下记录
+	a, _ = l.Attempt("bob")
+	if err := l.Refund(a); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if _, ok := l.store.(*MemoryStore).records["user:bob"]; ok {
+		t.Fatal("record kept after refunding the only attempt")
+	}
+}
+
+func TestAttemptWindow(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 5; i++ {
+		l.Attempt("alice")
+		now = now.Add(time.Minute)
+	}
+
+	now = now.Add(testPolicy.Window)
+	a, err := l.Attempt("alice")
+	if err != nil || a.Wait != 0 || a.Record.Failures != 1 {
+		t.Fatalf("after window: %+v, err %v; want 1 failure", a, err)
+	}
+}
@@ -0,0 +1,112 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	// 注册 GIF 解码器
+	_ "image/gif"
+)
+
+// maxPixels 允许解码的最大像素数，防止解压炸弹耗尽内存
+const maxPixels = 40_000_000
+
+// ErrTooLarge 图片尺寸超过限制
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// Result 生成的缩略图
+type Result struct {
+	Data        []byte
+	ContentType string
+	// Width/Height 为原图尺寸
+	Width  int
+	Height int
+}
+
+// Generate 生成长边不超过 maxSize 的缩略图
+// JPEG 原图输出 JPEG，其他格式输出 PNG 以保留透明度
+func Generate(r io.ReadSeeker, maxSize int) (*Result, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := resize(src, maxSize)
+
+	var buf bytes.Buffer
+	result := &Result{Width: cfg.Width, Height: cfg.Height}
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		result.ContentType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, thumb)
+		result.ContentType = "image/png"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Data = buf.Bytes()
+	return result, nil
+}
+
+// resize 按比例缩小图片，每个目标像素取其覆盖的源像素的平均值
+func resize(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSize && h <= maxSize {
+		return src
+	}
+
+	dw, dh := maxSize, maxSize
+	if w > h {
+		dh = max(1, h*maxSize/w)
+	} else {
+		dw = max(1, w*maxSize/h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0 := bounds.Min.Y + y*h/dh
+		sy1 := max(sy0+1, bounds.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			sx0 := bounds.Min.X + x*w/dw
+			sx1 := max(sx0+1, bounds.Min.X+(x+1)*w/dw)
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n),
+				G: uint8(g / n),
+				B: uint8(b / n),
+				A: uint8(a / n),
+			})
+		}
+	}
+	return dst
+}
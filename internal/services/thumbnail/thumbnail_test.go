@@ -0,0 +1,106 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG 生成 w×h 的 PNG 图片
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withPNGSize 修改 PNG 头部声明的尺寸（并重新计算 CRC），像素数据保持不变，模拟解压炸弹
+func withPNGSize(data []byte, w, h uint32) []byte {
+	out := bytes.Clone(data)
+	// 8 字节文件签名之后是 IHDR：长度(4) 类型(4) 宽(4) 高(4) ... CRC(4)
+	binary.BigEndian.PutUint32(out[16:], w)
+	binary.BigEndian.PutUint32(out[20:], h)
+	binary.BigEndian.PutUint32(out[29:], crc32.ChecksumIEEE(out[12:29]))
+	return out
+}
+
+func TestGenerateRejectsTooManyPixels(t *testing.T) {
+	data := encodePNG(t, 1, 1)
+
+	tests := []struct {
+		name string
+		w, h uint32
+	}{
+		{"huge square", 10000, 10000},
+		{"just over the limit", maxPixels/1000 + 1, 1000},
+		{"very wide", 1 << 30, 1},
+	}
+	for _, tt := range tests {
+		if _, err := Generate(bytes.NewReader(withPNGSize(data, tt.w, tt.h)), 64); err != ErrTooLarge {
+			t.Errorf("%s: Generate = %v, want ErrTooLarge", tt.name, err)
+		}
+	}
+
+	// 恰好等于上限的尺寸通过检查，之后因为像素数据不完整而解码失败
+	if _, err := Generate(bytes.NewReader(withPNGSize(data, maxPixels/1000, 1000)), 64); err == ErrTooLarge {
+		t.Error("image at the pixel limit was rejected as too large")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var jpegData bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x40, A: 0xff})
+		}
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		width       int
+		height      int
+		thumbW      int
+		thumbH      int
+	}{
+		{"jpeg is downscaled", jpegData.Bytes(), "image/jpeg", 200, 100, 64, 32},
+		{"tall png keeps aspect", encodePNG(t, 30, 120), "image/png", 30, 120, 16, 64},
+		{"small png is not enlarged", encodePNG(t, 20, 10), "image/png", 20, 10, 20, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Generate(bytes.NewReader(tt.data), 64)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			if result.ContentType != tt.contentType || result.Width != tt.width || result.Height != tt.height {
+				t.Fatalf("got %s %dx%d, want %s %dx%d",
+					result.ContentType, result.Width, result.Height, tt.contentType, tt.width, tt.height)
+			}
+
+			thumb, _, err := image.DecodeConfig(bytes.NewReader(result.Data))
+			if err != nil {
+				t.Fatalf("decode thumbnail: %v", err)
+			}
+			if thumb.Width != tt.thumbW || thumb.Height != tt.thumbH {
+				t.Fatalf("thumbnail is %dx%d, want %dx%d", thumb.Width, thumb.Height, tt.thumbW, tt.thumbH)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Purpose 令牌用途，不同用途的令牌不能混用
+type Purpose string
+
+const (
+	PurposeVerifyEmail   Purpose = "verify_email"   // 验证邮箱
+	PurposeResetPassword Purpose = "reset_password" // 重置密码
+)
+
+var (
+	// ErrInvalid 令牌签名无效、已过期、已使用或用途不符
+	ErrInvalid = errors.New("invalid or expired token")
+	// ErrTooSoon 距离上次为同一用户签发同一用途的令牌时间过短
+	ErrTooSoon = errors.New("token requested too recently")
+)
+
+// minInterval 同一用户同一用途两次签发之间的最小间隔，避免被用来频繁发送邮件
+const minInterval = time.Minute
+
+// queryRower 可以是 *sql.DB 或 *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Issuer 签发和核销一次性令牌
+//
+// 令牌是用密钥签名的随机 ID，数据库 user_tokens 表只保存 ID 的 SHA-256，
+// 核销时在数据库中标记为已使用，因此每个令牌只能使用一次。
+type Issuer struct {
+	db     *sql.DB
+	codecs []securecookie.Codec
+}
+
+// NewIssuer 创建 Issuer，keyPairs 用于签名令牌
+func NewIssuer(db *sql.DB, keyPairs ...[]byte) *Issuer {
+	return &Issuer{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+	}
+}
+
+// Issue 为用户签发一个有效期为 ttl 的令牌，同一用户同一用途之前的令牌随之删除
+func (i *Issuer) Issue(userID int, purpose Purpose, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var recent bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_tokens
+			WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+			  AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $3)
+		)
+	`, userID, purpose, minInterval.Seconds()).Scan(&recent); err != nil {
+		return "", err
+	}
+	if recent {
+		return "", ErrTooSoon
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2",
+		userID, purpose,
+	); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4))
+	`, hash(id), userID, purpose, ttl.Seconds()); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return securecookie.EncodeMulti(string(purpose), id, i.codecs...)
+}
+
+// Consume 核销令牌并返回其所属用户 ID；q 可以是事务，与后续操作一起提交
+func (i *Issuer) Consume(q queryRower, token string, purpose Purpose) (int, error) {
+	var id string
+	if err := securecookie.DecodeMulti(string(purpose), token, &id, i.codecs...); err != nil {
+		return 0, ErrInvalid
+	}
+
+	var userID int
+	err := q.QueryRow(`
+		UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id
+	`, hash(id), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalid
+	}
+	return userID, err
+}
+
+// hash 返回令牌 ID 的 SHA-256，数据库泄露时无法直接拿来使用
+func hash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,157 @@
+package token
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	_ "github.com/lib/pq"
+)
+
+// testDatabaseEnv 指向测试数据库的连接字符串，未设置时跳过需要 Postgres 的测试
+const testDatabaseEnv = "GOCHAT_TEST_DATABASE_URL"
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// openTestDB 连接测试数据库并运行全部迁移
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../../migrations/*.sql")
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		content, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("read migration: %v", err)
+		}
+		if _, err := db.Exec(string(content)); err != nil {
+			t.Fatalf("run migration %s: %v", migration, err)
+		}
+	}
+	return db
+}
+
+// createTestUser 创建一个测试用户，测试结束后删除（令牌随之级联删除）
+func createTestUser(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	name := fmt.Sprintf("token_test_%d", time.Now().UnixNano())
+	var id int
+	if err := db.QueryRow(
+		"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, '') RETURNING id",
+		name, name+"@example.com",
+	).Scan(&id); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", id) })
+	return id
+}
+
+// TestConsumeRejectsForgedToken 签名无效或用途不符的令牌在查询数据库之前就被拒绝
+func TestConsumeRejectsForgedToken(t *testing.T) {
+	issuer := NewIssuer(nil, testKey)
+
+	verify, err := securecookie.EncodeMulti(string(PurposeVerifyEmail), "id", issuer.codecs...)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	other, err := securecookie.EncodeMulti(string(PurposeResetPassword), "id",
+		securecookie.CodecsFromPairs([]byte("another key of thirty two bytes!"))...)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"garbage", "not-a-token"},
+		{"wrong purpose", verify},
+		{"wrong key", other},
+	}
+	for _, tt := range tests {
+		if _, err := issuer.Consume(nil, tt.token, PurposeResetPassword); err != ErrInvalid {
+			t.Errorf("%s: Consume = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestIssueAndConsume(t *testing.T) {
+	db := openTestDB(t)
+	issuer := NewIssuer(db, testKey)
+
+	t.Run("single use", func(t *testing.T) {
+		userID := createTestUser(t, db)
+		tok, err := issuer.Issue(userID, PurposeResetPassword, time.Hour)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+
+		got, err := issuer.Consume(db, tok, PurposeResetPassword)
+		if err != nil || got != userID {
+			t.Fatalf("Consume = %d, %v; want %d", got, err, userID)
+		}
+		if _, err := issuer.Consume(db, tok, PurposeResetPassword); err != ErrInvalid {
+			t.Fatalf("second Consume = %v, want ErrInvalid", err)
+		}
+	})
+
+	t.Run("purpose", func(t *testing.T) {
+		userID := createTestUser(t, db)
+		tok, err := issuer.Issue(userID, PurposeVerifyEmail, time.Hour)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+
+		if _, err := issuer.Consume(db, tok, PurposeResetPassword); err != ErrInvalid {
+			t.Fatalf("Consume with another purpose = %v, want ErrInvalid", err)
+		}
+		// 用错用途不会消耗令牌
+		if got, err := issuer.Consume(db, tok, PurposeVerifyEmail); err != nil || got != userID {
+			t.Fatalf("Consume = %d, %v; want %d", got, err, userID)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		userID := createTestUser(t, db)
+		tok, err := issuer.Issue(userID, PurposeVerifyEmail, -time.Second)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		if _, err := issuer.Consume(db, tok, PurposeVerifyEmail); err != ErrInvalid {
+			t.Fatalf("Consume expired token = %v, want ErrInvalid", err)
+		}
+	})
+
+	t.Run("too soon", func(t *testing.T) {
+		userID := createTestUser(t, db)
+		if _, err := issuer.Issue(userID, PurposeVerifyEmail, time.Hour); err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		if _, err := issuer.Issue(userID, PurposeVerifyEmail, time.Hour); err != ErrTooSoon {
+			t.Fatalf("second Issue = %v, want ErrTooSoon", err)
+		}
+		// 其他用途不受影响
+		if _, err := issuer.Issue(userID, PurposeResetPassword, time.Hour); err != nil {
+			t.Fatalf("Issue with another purpose: %v", err)
+		}
+	})
+}
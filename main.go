@@ -1,12 +1,26 @@
 package main
 
 import (
+	"crypto/rand"
+	"fmt"
 	"go-chat/internal/database"
 	"go-chat/internal/handlers"
 	"go-chat/internal/middleware"
+	"go-chat/internal/services/apitoken"
+	"go-chat/internal/services/events"
 	"go-chat/internal/services/hub"
+	"go-chat/internal/services/mailer"
+	"go-chat/internal/services/oidc"
+	"go-chat/internal/services/session"
+	"go-chat/internal/services/storage"
+	"go-chat/internal/services/throttle"
+	"go-chat/internal/services/token"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -26,16 +40,81 @@ func main() {
 	}
 	defer database.Close()
 
-	// 运行数据库迁移
-	if err := database.RunMigrations("migrations/001_init.sql"); err != nil {
-		log.Printf("Warning: Migration failed: %v", err)
-		log.Println("Continuing anyway... (migrations may have already been applied)")
+	// 按文件名顺序运行数据库迁移
+	migrations, err := filepath.Glob("migrations/*.sql")
+	if err != nil {
+		log.Fatalf("Failed to list migrations: %v", err)
 	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		if err := database.RunMigrations(migration); err != nil {
+			log.Printf("Warning: Migration %s failed: %v", migration, err)
+			log.Println("Continuing anyway... (migrations may have already been applied)")
+		}
+	}
+
+	// 创建 session 存储，所有处理器共享同一个实例
+	secret := sessionSecret()
+	sessionStore := session.NewStore(database.DB, secret)
+	middleware.SetSessionStore(sessionStore)
+	middleware.SetTokenStore(apitoken.NewStore(database.DB))
+	stopCleanup := sessionStore.StartCleanup(sessionCleanupInterval)
+	defer stopCleanup()
 
 	// 创建并启动 WebSocket Hub
-	wsHub := hub.NewHub()
+	wsHub, err := newHub()
+	if err != nil {
+		log.Fatalf("Failed to create WebSocket hub: %v", err)
+	}
+	defer wsHub.Close()
+
+	// 房间事件写入投递队列，由后台任务签名后发送给订阅方
+	dispatcher := events.NewDispatcher(database.DB, events.Options{
+		AllowInsecure: os.Getenv("EVENT_DELIVERY_ALLOW_INSECURE") == "true",
+	})
+	wsHub.SetEventSink(dispatcher)
+	stopDelivery := dispatcher.Start(eventDeliveryInterval)
+	defer stopDelivery()
+
 	go wsHub.Run()
 
+	// 创建附件存储
+	fileStore, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to create attachment storage: %v", err)
+	}
+
+	// 邮箱验证和重置密码，一次性令牌与 session 使用同一个签名密钥
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to create mailer: %v", err)
+	}
+	// 登录失败限流，计数按用户名和 IP 分别保存
+	throttleStore, err := newThrottleStore()
+	if err != nil {
+		log.Fatalf("Failed to create login throttle store: %v", err)
+	}
+	stopThrottleCleanup := throttle.StartCleanup(throttleStore, sessionCleanupInterval, loginThrottleWindow)
+	defer stopThrottleCleanup()
+	loginThrottle := &handlers.LoginThrottle{
+		Users: throttle.NewLimiter(throttleStore, "user", userLoginPolicy),
+		IPs:   throttle.NewLimiter(throttleStore, "ip", ipLoginPolicy),
+	}
+
+	accountOptions := &handlers.AccountOptions{
+		Mailer:                   mail,
+		Tokens:                   token.NewIssuer(database.DB, secret),
+		BaseURL:                  appBaseURL(),
+		RequireEmailVerification: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		Throttle:                 loginThrottle,
+	}
+
+	// 单点登录身份提供方
+	oidcProviders, err := oidc.ProvidersFromEnv(accountOptions.BaseURL)
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC providers: %v", err)
+	}
+
 	// 创建路由
 	r := mux.NewRouter()
 
@@ -46,27 +125,109 @@ func main() {
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	})
-	r.HandleFunc("/login", handlers.ShowLoginPage).Methods("GET")
+	r.HandleFunc("/login", handlers.ShowLoginPage(oidcProviders)).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/login", handlers.OIDCLogin(oidcProviders)).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", handlers.OIDCCallback(oidcProviders)).Methods("GET")
 	r.HandleFunc("/register", handlers.ShowRegisterPage).Methods("GET")
-	r.HandleFunc("/api/login", handlers.Login).Methods("POST")
-	r.HandleFunc("/api/register", handlers.Register).Methods("POST")
-	r.HandleFunc("/logout", handlers.Logout).Methods("GET")
+	r.HandleFunc("/api/login", handlers.Login(accountOptions)).Methods("POST")
+	r.HandleFunc("/login/2fa", handlers.ShowLogin2FAPage).Methods("GET")
+	r.HandleFunc("/api/login/2fa", handlers.VerifyLogin2FA(loginThrottle)).Methods("POST")
+	r.HandleFunc("/api/register", handlers.Register(accountOptions)).Methods("POST")
+	r.HandleFunc("/verify-email", handlers.ShowVerifyEmail(accountOptions)).Methods("GET")
+	r.HandleFunc("/api/verify-email/resend", handlers.ResendVerification(accountOptions)).Methods("POST")
+	r.HandleFunc("/forgot-password", handlers.ShowForgotPasswordPage).Methods("GET")
+	r.HandleFunc("/reset-password", handlers.ShowResetPasswordPage).Methods("GET")
+	r.HandleFunc("/api/password/forgot", handlers.ForgotPassword(accountOptions)).Methods("POST")
+	r.HandleFunc("/api/password/reset", handlers.ResetPassword(wsHub, accountOptions)).Methods("POST")
+	r.HandleFunc("/logout", handlers.Logout(wsHub)).Methods("GET")
+
+	// incoming webhook：URL 中的密钥即凭据，不需要登录
+	webhookLimiter := throttle.NewRateLimiter(webhookRateInterval, webhookRateBurst)
+	r.HandleFunc("/hooks/{token:[0-9a-f]{64}}", handlers.PostWebhookMessage(wsHub, webhookLimiter)).Methods("POST")
 
 	// 需要认证的路由
 	authRouter := r.PathPrefix("/").Subrouter()
 	authRouter.Use(middleware.RequireAuth)
 
+	authRouter.HandleFunc("/api/logout-all", middleware.SessionOnly(handlers.LogoutEverywhere(wsHub))).Methods("POST")
+	authRouter.HandleFunc("/settings/sessions", middleware.SessionOnly(handlers.ShowSessions)).Methods("GET")
+	authRouter.HandleFunc("/settings/security", middleware.SessionOnly(handlers.ShowSecurityPage)).Methods("GET")
+	authRouter.HandleFunc("/api/2fa", middleware.SessionOnly(handlers.GetTwoFactorStatus)).Methods("GET")
+	authRouter.HandleFunc("/api/2fa/setup", middleware.SessionOnly(handlers.SetupTwoFactor)).Methods("POST")
+	authRouter.HandleFunc("/api/2fa/enable", middleware.SessionOnly(handlers.EnableTwoFactor)).Methods("POST")
+	authRouter.HandleFunc("/api/2fa/disable", middleware.SessionOnly(handlers.DisableTwoFactor(loginThrottle))).Methods("POST")
+	authRouter.HandleFunc("/api/sessions", middleware.SessionOnly(handlers.GetSessions)).Methods("GET")
+	authRouter.HandleFunc("/api/sessions/revoke-others", middleware.SessionOnly(handlers.RevokeOtherSessions(wsHub))).Methods("POST")
+	authRouter.HandleFunc("/api/sessions/{sessionId:[0-9]+}", middleware.SessionOnly(handlers.RevokeSession(wsHub))).Methods("DELETE")
+
 	// 房间相关路由
+	authRouter.HandleFunc("/settings/tokens", middleware.SessionOnly(handlers.ShowTokensPage)).Methods("GET")
+	authRouter.HandleFunc("/api/tokens", middleware.SessionOnly(handlers.GetAPITokens)).Methods("GET")
+	authRouter.HandleFunc("/api/tokens", middleware.SessionOnly(handlers.CreateAPIToken)).Methods("POST")
+	authRouter.HandleFunc("/api/tokens/{tokenId:[0-9]+}", middleware.SessionOnly(handlers.RevokeAPIToken(wsHub))).Methods("DELETE")
+	authRouter.HandleFunc("/api/bots", middleware.SessionOnly(handlers.GetBots)).Methods("GET")
+	authRouter.HandleFunc("/api/bots", middleware.SessionOnly(handlers.CreateBot)).Methods("POST")
+	authRouter.HandleFunc("/api/bots/{botId:[0-9]+}", middleware.SessionOnly(handlers.DeleteBot(wsHub))).Methods("DELETE")
+	authRouter.HandleFunc("/api/admin/login-audit", middleware.SessionOnly(handlers.GetLoginAudit)).Methods("GET")
+	authRouter.HandleFunc("/api/admin/users/{userId:[0-9]+}/unlock", middleware.SessionOnly(handlers.UnlockUser(loginThrottle))).Methods("POST")
 	authRouter.HandleFunc("/rooms", handlers.ShowRoomsList).Methods("GET")
+	authRouter.HandleFunc("/rooms/browse", handlers.ShowPublicRooms).Methods("GET")
 	authRouter.HandleFunc("/rooms/{id:[0-9]+}", handlers.ShowRoom).Methods("GET")
 	authRouter.HandleFunc("/api/rooms", handlers.CreateRoom).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/public", handlers.GetPublicRooms).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}", handlers.UpdateRoomSettings(wsHub)).Methods("PUT")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}", handlers.DeleteRoom(wsHub, fileStore)).Methods("DELETE")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/archive", handlers.ArchiveRoom(wsHub, true)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/unarchive", handlers.ArchiveRoom(wsHub, false)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/transfer", handlers.TransferOwnership(wsHub)).Methods("POST")
 	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/members", handlers.GetRoomMembers).Methods("GET")
-	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/invite", handlers.InviteMember).Methods("POST")
-	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/members/{memberId:[0-9]+}", handlers.RemoveMember).Methods("DELETE")
-	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/leave", handlers.LeaveRoom).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/presence", handlers.GetRoomPresence(wsHub)).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/invite", handlers.InviteMember(wsHub)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/webhooks", handlers.GetRoomWebhooks).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/webhooks", handlers.CreateWebhook(accountOptions.BaseURL)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/webhooks/{webhookId:[0-9]+}", handlers.DeleteWebhook).Methods("DELETE")
+	authRouter.HandleFunc("/rooms/{id:[0-9]+}/event-subscriptions", handlers.ShowEventSubscriptionsPage).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions", handlers.GetEventSubscriptions).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions", handlers.CreateEventSubscription(dispatcher)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions/{subscriptionId:[0-9]+}", handlers.DeleteEventSubscription).Methods("DELETE")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions/{subscriptionId:[0-9]+}/deliveries", handlers.GetEventDeliveries).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions/{subscriptionId:[0-9]+}/deliveries/{deliveryId:[0-9]+}/attempts", handlers.GetDeliveryAttempts).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/event-subscriptions/{subscriptionId:[0-9]+}/deliveries/{deliveryId:[0-9]+}/redeliver", handlers.RedeliverEvent(dispatcher)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/invites", handlers.CreateInvite).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/invites", handlers.GetRoomInvites).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/invites/{inviteId:[0-9]+}", handlers.RevokeInvite).Methods("DELETE")
+	authRouter.HandleFunc("/invite/{token:[0-9a-f]+}", handlers.ShowInvite).Methods("GET")
+	authRouter.HandleFunc("/api/invites/{token:[0-9a-f]+}/accept", handlers.AcceptInvite(wsHub)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/members/{memberId:[0-9]+}", handlers.RemoveMember(wsHub)).Methods("DELETE")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/members/{memberId:[0-9]+}/role", handlers.ChangeMemberRole(wsHub)).Methods("PUT")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/leave", handlers.LeaveRoom(wsHub)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/join", handlers.JoinRoom(wsHub)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/join-requests", handlers.CreateJoinRequest(wsHub)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/join-requests", handlers.GetJoinRequests).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/join-requests/{requestId:[0-9]+}/approve", handlers.DecideJoinRequest(wsHub, true)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/join-requests/{requestId:[0-9]+}/deny", handlers.DecideJoinRequest(wsHub, false)).Methods("POST")
+	authRouter.HandleFunc("/api/dms", handlers.OpenDM).Methods("POST")
+
+	// 消息相关路由
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages", handlers.GetRoomMessages).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}", handlers.EditMessage(wsHub)).Methods("PUT")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}", handlers.DeleteMessage(wsHub)).Methods("DELETE")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}/edits", handlers.GetMessageEdits).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}/thread", handlers.GetThread).Methods("GET")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}/pin", handlers.PinMessage(wsHub, true)).Methods("POST")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/messages/{messageId:[0-9]+}/pin", handlers.PinMessage(wsHub, false)).Methods("DELETE")
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/pins", handlers.GetPinnedMessages).Methods("GET")
+
+	// 附件相关路由
+	authRouter.HandleFunc("/api/rooms/{id:[0-9]+}/attachments", handlers.UploadAttachment(fileStore)).Methods("POST")
+	authRouter.HandleFunc("/api/attachments/{attachmentId:[0-9]+}", handlers.DownloadAttachment(fileStore, false)).Methods("GET")
+	authRouter.HandleFunc("/api/attachments/{attachmentId:[0-9]+}/thumbnail", handlers.DownloadAttachment(fileStore, true)).Methods("GET")
+
+	// 搜索路由
+	authRouter.HandleFunc("/api/search", handlers.SearchMessages).Methods("GET")
 
 	// WebSocket 路由
-	authRouter.HandleFunc("/ws/rooms/{id:[0-9]+}", handlers.HandleWebSocket(wsHub)).Methods("GET")
+	authRouter.HandleFunc("/ws/rooms/{id:[0-9]+}", handlers.HandleWebSocket(wsHub, accountOptions.BaseURL)).Methods("GET")
 
 	// 启动服务器
 	port := ":8080"
@@ -78,3 +239,92 @@ func main() {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// sessionCleanupInterval 清理过期 session 的间隔
+const sessionCleanupInterval = time.Hour
+
+// webhookRateInterval / webhookRateBurst 每个 incoming webhook 最多连续发送 20 条消息，之后每秒恢复一条
+const (
+	webhookRateInterval = time.Second
+	webhookRateBurst    = 20
+)
+
+// eventDeliveryInterval 检查投递队列的间隔，有积压时会连续处理
+const eventDeliveryInterval = 2 * time.Second
+
+// loginThrottleWindow 登录失败计数的有效期，最后一次失败超过该时间后重新计数
+const loginThrottleWindow = 24 * time.Hour
+
+var (
+	// userLoginPolicy 同一用户名：3 次失败后从 1 秒开始指数退避，10 次失败后锁定 15 分钟
+	userLoginPolicy = throttle.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          loginThrottleWindow,
+	}
+	// ipLoginPolicy 同一 IP：同一出口 IP 后可能有多个用户，只做指数退避不锁定
+	ipLoginPolicy = throttle.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+// sessionSecret 读取 SESSION_SECRET 作为 cookie 签名密钥
+// 未设置时生成随机密钥：重启后所有用户需要重新登录，多实例部署时各实例之间的登录也不互通
+func sessionSecret() []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Println("Warning: SESSION_SECRET is not set, using a random key; sessions will not survive a restart")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate session key: %v", err)
+	}
+	return key
+}
+
+// appBaseURL 读取 APP_BASE_URL 作为邮件中链接的前缀
+func appBaseURL() string {
+	if baseURL := os.Getenv("APP_BASE_URL"); baseURL != "" {
+		return baseURL
+	}
+
+	log.Println("Warning: APP_BASE_URL is not set, links in emails will point to http://localhost:8080")
+	return "http://localhost:8080"
+}
+
+// newThrottleStore 根据 THROTTLE_BACKEND 环境变量创建登录失败计数存储
+// memory（默认）: 进程内计数；postgres: 保存在 login_throttle 表中，多个实例共享计数
+func newThrottleStore() (throttle.Store, error) {
+	switch backend := os.Getenv("THROTTLE_BACKEND"); backend {
+	case "", "memory":
+		return throttle.NewMemoryStore(), nil
+	case "postgres":
+		return throttle.NewPostgresStore(database.DB), nil
+	default:
+		return nil, fmt.Errorf("unknown THROTTLE_BACKEND %q", backend)
+	}
+}
+
+// newHub 根据 HUB_BACKEND 环境变量创建 Hub
+// memory（默认）: 单实例内存广播；postgres: 通过 LISTEN/NOTIFY 在多个实例间广播
+func newHub() (*hub.Hub, error) {
+	switch backend := os.Getenv("HUB_BACKEND"); backend {
+	case "", "memory":
+		return hub.NewHub(), nil
+	case "postgres":
+		pgBackend, err := hub.NewPostgresBackend(database.DB, database.ConnString())
+		if err != nil {
+			return nil, err
+		}
+		return hub.NewHubWithBackend(pgBackend), nil
+	default:
+		return nil, fmt.Errorf("unknown HUB_BACKEND %q", backend)
+	}
+}
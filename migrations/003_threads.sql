@@ -0,0 +1,6 @@
+-- 消息线程回复
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id);
@@ -0,0 +1,6 @@
+-- 消息全文搜索
+-- 使用 simple 配置，不做词干化，适用于多语言内容
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,8 @@
+-- 私聊：房间类型和一对一私聊的唯一键
+-- kind: 'channel'（普通聊天室）、'dm'（一对一私聊）、'group_dm'（多人私聊）
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'channel';
+
+-- 一对一私聊的唯一键，格式为 "小用户ID:大用户ID"，保证两人之间只有一个私聊房间
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS dm_key VARCHAR(50);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_dm_key ON rooms(dm_key);
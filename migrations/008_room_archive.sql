@@ -0,0 +1,2 @@
+-- 房间归档：archived_at 非空表示房间已归档，只能查看不能发言
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
@@ -0,0 +1,6 @@
+-- 房间角色：room_members.role 新增 'admin'（管理员）和 'moderator'（协管员），权限见 internal/permissions
+-- 消息置顶
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS pinned_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_pinned ON messages(room_id, pinned_at) WHERE pinned_at IS NOT NULL;
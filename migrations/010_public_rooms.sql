@@ -0,0 +1,4 @@
+-- 公开房间：visibility 为 'private'（仅受邀加入）或 'public'（出现在房间目录中，可自行加入）
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS visibility VARCHAR(20) NOT NULL DEFAULT 'private';
+
+CREATE INDEX IF NOT EXISTS idx_rooms_public ON rooms(created_at DESC) WHERE visibility = 'public';
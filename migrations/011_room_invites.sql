@@ -0,0 +1,15 @@
+-- 邀请链接：持有 token 的登录用户可以加入房间
+-- max_uses、expires_at 为空表示不限次数、不过期；revoked_at 非空表示已撤销
+CREATE TABLE IF NOT EXISTS room_invites (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    max_uses INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_invites_room_id ON room_invites(room_id);
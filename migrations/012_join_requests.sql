@@ -0,0 +1,16 @@
+-- 加入申请：visibility 为 'request' 的房间会出现在房间目录中，用户申请后由创建者或管理员审批
+-- status: 'pending'（待审批）、'approved'（已通过）、'denied'（已拒绝）
+CREATE TABLE IF NOT EXISTS room_join_requests (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    decided_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 同一用户对同一房间只能有一个待审批的申请
+CREATE UNIQUE INDEX IF NOT EXISTS idx_room_join_requests_pending
+    ON room_join_requests(room_id, user_id) WHERE status = 'pending';
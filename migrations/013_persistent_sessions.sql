@@ -0,0 +1,2 @@
+-- session 保存在数据库中：未登录的 session 没有 user_id
+ALTER TABLE sessions ALTER COLUMN user_id DROP NOT NULL;
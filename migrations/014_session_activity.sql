@@ -0,0 +1,8 @@
+-- 会话管理：记录每个 session 的最近活动时间、IP 和 User-Agent
+-- public_id 用于在会话管理页面中标识 session，避免把 session ID 暴露给前端
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS public_id BIGSERIAL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_public_id ON sessions(public_id);
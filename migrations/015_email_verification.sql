@@ -0,0 +1,24 @@
+-- 邮箱验证：email_verified_at 为空表示邮箱尚未验证
+-- 只在第一次添加该列时把已有用户视为已验证，避免开启 REQUIRE_EMAIL_VERIFICATION 后老用户无法登录
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'email_verified_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+        UPDATE users SET email_verified_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+    END IF;
+END $$;
+
+-- 一次性令牌（邮箱验证、重置密码），只保存令牌 ID 的 SHA-256
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL, -- 'verify_email' 或 'reset_password'
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens(user_id, purpose);
@@ -0,0 +1,16 @@
+-- 两步验证（TOTP）
+-- totp_secret 不为空但 totp_enabled_at 为空表示正在绑定、尚未确认
+-- totp_last_step 最近一次使用的验证码周期，防止同一验证码被重复使用
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- 恢复码，只保存 SHA-256，每个恢复码只能使用一次
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, code_hash)
+);
@@ -0,0 +1,24 @@
+-- 机器人账号：没有密码和邮箱，只能通过 API 令牌访问，bot_owner_id 为创建它的用户
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bot_owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_users_bot_owner ON users(bot_owner_id) WHERE bot_owner_id IS NOT NULL;
+
+-- API 令牌，只保存令牌的 SHA-256
+-- user_id 为令牌代表的用户（创建者本人或其机器人），created_by 为创建令牌的用户
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(16) NOT NULL, -- 令牌开头几位，用于在列表中辨认
+    scopes TEXT[] NOT NULL,      -- 'read'、'write'
+    expires_at TIMESTAMP,        -- 为空表示永不过期
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_created_by ON api_tokens(created_by);
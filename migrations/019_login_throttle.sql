@@ -0,0 +1,26 @@
+-- 管理员可以查看登录审计记录和解锁被锁定的账号
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- 登录失败计数（THROTTLE_BACKEND=postgres 时使用），key 为 "user:<用户名小写>" 或 "ip:<IP>"
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttle_last_failure ON login_throttle(last_failure_at);
+
+-- 登录审计记录：密码错误、账号锁定和管理员解锁
+-- username 为尝试登录时填写的用户名（可能不存在），user_id 为对应的用户
+CREATE TABLE IF NOT EXISTS login_audit (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    username VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    event VARCHAR(20) NOT NULL, -- 'failure'、'lockout'、'unlock'
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- 解锁操作的管理员
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_audit_username ON login_audit(LOWER(username), created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_login_audit_created_at ON login_audit(created_at);
@@ -0,0 +1,18 @@
+-- 房间的 incoming webhook：外部系统通过带密钥的 URL 向房间发送消息，只保存密钥的 SHA-256
+-- 消息以创建者的身份保存（messages.user_id），显示名称取 messages.sender_name
+CREATE TABLE IF NOT EXISTS room_webhooks (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_webhooks_room ON room_webhooks(room_id);
+
+-- 集成发送的消息：sender_name 非空表示消息来自集成，删除 webhook 后消息保留
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS webhook_id INTEGER REFERENCES room_webhooks(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS sender_name VARCHAR(100);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS embeds JSONB; -- 卡片：[{"title", "title_link", "text", "color"}]
@@ -0,0 +1,46 @@
+-- 房间的事件订阅（outgoing webhook）：房间事件以 POST 发送到 url，请求体用 secret 做 HMAC-SHA256 签名
+-- secret 签名时需要原文，因此明文保存，接口只在创建时返回一次
+CREATE TABLE IF NOT EXISTS room_event_subscriptions (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events TEXT[] NOT NULL, -- message.created、member.joined、member.removed、room.updated
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_event_subscriptions_room ON room_event_subscriptions(room_id);
+
+-- 投递队列：每个事件对每个订阅一行，status 为 pending（等待投递或重试）、succeeded 或 dead（重试用完）
+-- locked_until 是投递进程的租约，进程在投递中途退出时租约过期，其他进程可以重新领取
+CREATE TABLE IF NOT EXISTS event_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES room_event_subscriptions(id) ON DELETE CASCADE,
+    event_id VARCHAR(32) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_deliveries_pending ON event_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_event_deliveries_subscription ON event_deliveries(subscription_id, created_at DESC);
+
+-- 每次投递尝试的日志，status_code 为空表示没有收到响应（连接失败、超时）
+CREATE TABLE IF NOT EXISTS event_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES event_deliveries(id) ON DELETE CASCADE,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_delivery_attempts_delivery ON event_delivery_attempts(delivery_id);
@@ -0,0 +1,10 @@
+-- 超过 NOTIFY 负载上限的跨实例广播（`HUB_BACKEND=postgres` 时使用）
+-- 发布方先写入本表，再通过 NOTIFY 只发送记录 ID，其他实例按 ID 读取消息内容
+CREATE TABLE IF NOT EXISTS hub_broadcasts (
+    id BIGSERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hub_broadcasts_created_at ON hub_broadcasts(created_at);